@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxAltTextLength caps the alt text of attachments, in characters.
+const maxAltTextLength = 1000
+
+// Attachment is an image attached to a post.
+type Attachment struct {
+	IDAttachment int    `json:"idAttachment"`
+	PostID       int    `json:"idPost"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	ContentType  string `json:"contentType"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	// Size is the size of the stored file in bytes.
+	Size      int    `json:"size"`
+	AltText   string `json:"altText"`
+	CreatedAt string `json:"created_at"`
+	// BlobKey and ThumbnailKey locate the files in the BlobStore.
+	BlobKey      string `json:"-"`
+	ThumbnailKey string `json:"-"`
+}
+
+// newBlobKey returns a random key with the given extension. The first two
+// characters are repeated as a directory so no single one grows too large.
+func newBlobKey(ext string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(b[:])
+	return name[:2] + "/" + name + ext, nil
+}
+
+// putBlob stores data under a new key and returns it.
+func (s *Server) putBlob(ctx context.Context, data []byte, contentType, ext string) (string, error) {
+	key, err := newBlobKey(ext)
+	if err != nil {
+		return "", err
+	}
+	return key, s.blobs.Put(ctx, key, bytes.NewReader(data), contentType)
+}
+
+// deleteBlobs removes the files of attachments whose rows are gone. Failures
+// only leave unreferenced files behind, so they are logged.
+func (s *Server) deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("media: delete %s: %v", key, err)
+		}
+	}
+}
+
+// withURLs fills in the download addresses of a.
+func (s *Server) withURLs(a Attachment) Attachment {
+	a.URL = s.blobs.URL(a.BlobKey)
+	a.ThumbnailURL = s.blobs.URL(a.ThumbnailKey)
+	return a
+}
+
+// withAttachments fills in the attachments of posts.
+func (s *Server) withAttachments(ctx context.Context, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.IDPost
+	}
+
+	attachments, err := s.store.ListAttachments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		for _, a := range attachments[posts[i].IDPost] {
+			posts[i].Attachments = append(posts[i].Attachments, s.withURLs(a))
+		}
+	}
+	return nil
+}
+
+// AddAttachment handles POST /addAttachment, a multipart form with the
+// fields postID, altText and file. Images are re-encoded without their
+// metadata and get a thumbnail; see processImage.
+func (s *Server) AddAttachment(c echo.Context) error {
+	req := c.Request()
+	// Leave some room for the other fields and the multipart framing
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, int64(s.cfg.MaxUploadSize)+64<<10)
+	tooLarge := func() error {
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{
+			"error": "File must not be larger than " + strconv.Itoa(s.cfg.MaxUploadSize) + " bytes",
+		})
+	}
+
+	file, err := c.FormFile("file")
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return tooLarge()
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "A file is required"})
+	}
+	if file.Size > int64(s.cfg.MaxUploadSize) {
+		return tooLarge()
+	}
+	postID, ok := parseID(c.FormValue("postID"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Post ID is required"})
+	}
+	altText := c.FormValue("altText")
+	if len([]rune(altText)) > maxAltTextLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Alt text must not be longer than " + strconv.Itoa(maxAltTextLength) + " characters"})
+	}
+
+	// Only the author or a moderator may add to the post
+	if ok, err := s.authorizePostChange(c, postID); !ok {
+		return err
+	}
+	ctx := req.Context()
+	existing, err := s.store.ListAttachments(ctx, []int{postID})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
+	}
+	if len(existing[postID]) >= s.cfg.MaxAttachments {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "A post can have at most " + strconv.Itoa(s.cfg.MaxAttachments) + " attachments"})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Failed to read file"})
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Failed to read file"})
+	}
+
+	img, err := processImage(data)
+	switch {
+	case errors.Is(err, errUnsupportedMedia):
+		return c.JSON(http.StatusUnsupportedMediaType, echo.Map{
+			"error":   "Unsupported file type",
+			"allowed": []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+		})
+	case errors.Is(err, errImageTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": "Image must not have more than " + strconv.Itoa(maxImagePixels) + " pixels"})
+	case errors.Is(err, errInvalidImage):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "File is not a valid image"})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to process image: " + err.Error()})
+	}
+
+	attachment := Attachment{
+		PostID:      postID,
+		ContentType: img.contentType,
+		Width:       img.width,
+		Height:      img.height,
+		Size:        len(img.data),
+		AltText:     altText,
+		CreatedAt:   formatTime(time.Now()),
+	}
+	attachment.BlobKey, err = s.putBlob(ctx, img.data, img.contentType, img.ext)
+	if err == nil {
+		attachment.ThumbnailKey, err = s.putBlob(ctx, img.thumbnail, img.thumbnailContentType, img.thumbnailExt)
+	}
+	if err == nil {
+		err = s.store.CreateAttachment(ctx, &attachment)
+	}
+	if err != nil {
+		s.deleteBlobs(ctx, attachment.BlobKey, attachment.ThumbnailKey)
+	}
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to store attachment: " + err.Error()})
+	}
+	s.publishPost(ctx, EventPostUpdated, postID)
+
+	return c.JSON(http.StatusCreated, s.withURLs(attachment))
+}
+
+// authorizeAttachmentChange looks up an attachment and writes the 404 or
+// 403 response if the caller may not modify the post it belongs to.
+func (s *Server) authorizeAttachmentChange(c echo.Context, attachmentID int) (Attachment, bool, error) {
+	attachment, err := s.store.GetAttachment(c.Request().Context(), attachmentID)
+	if errors.Is(err, ErrNotFound) {
+		return attachment, false, c.JSON(http.StatusNotFound, echo.Map{"error": "Attachment not found"})
+	}
+	if err != nil {
+		return attachment, false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachment"})
+	}
+	ok, err := s.authorizePostChange(c, attachment.PostID)
+	return attachment, ok, err
+}
+
+func (s *Server) EditAttachment(c echo.Context) error {
+	type EditRequest struct {
+		AttachmentID string `json:"attachmentID"`
+		AltText      string `json:"altText"`
+	}
+
+	var req EditRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	attachmentID, ok := parseID(req.AttachmentID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Attachment ID is required"})
+	}
+	if len([]rune(req.AltText)) > maxAltTextLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Alt text must not be longer than " + strconv.Itoa(maxAltTextLength) + " characters"})
+	}
+
+	attachment, ok, err := s.authorizeAttachmentChange(c, attachmentID)
+	if !ok {
+		return err
+	}
+	ctx := c.Request().Context()
+	err = s.store.UpdateAttachmentAltText(ctx, attachmentID, req.AltText)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Attachment not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update attachment: " + err.Error()})
+	}
+	s.publishPost(ctx, EventPostUpdated, attachment.PostID)
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Attachment updated successfully"})
+}
+
+func (s *Server) DeleteAttachment(c echo.Context) error {
+	type DeleteRequest struct {
+		AttachmentID string `json:"attachmentID"`
+	}
+
+	var req DeleteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	attachmentID, ok := parseID(req.AttachmentID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Attachment ID is required"})
+	}
+
+	attachment, ok, err := s.authorizeAttachmentChange(c, attachmentID)
+	if !ok {
+		return err
+	}
+	ctx := c.Request().Context()
+	err = s.store.DeleteAttachment(ctx, attachmentID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Attachment not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to delete attachment: " + err.Error()})
+	}
+	s.deleteBlobs(ctx, attachment.BlobKey, attachment.ThumbnailKey)
+	s.publishPost(ctx, EventPostUpdated, attachment.PostID)
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Attachment deleted successfully"})
+}
+
+// GetMedia handles GET /media/*, the files of the blob store. Keys are never
+// reused, so clients may cache them for good.
+func (s *Server) GetMedia(c echo.Context) error {
+	blob, err := s.blobs.Get(c.Request().Context(), c.Param("*"))
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "File not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to read file"})
+	}
+	defer blob.Close()
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set(echo.HeaderContentType, blob.ContentType)
+	if rs, ok := blob.ReadCloser.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), "", blob.ModTime, rs)
+		return nil
+	}
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(blob.Size, 10))
+	return c.Stream(http.StatusOK, blob.ContentType, blob)
+}
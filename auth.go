@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userContextKey is the echo.Context key under which loadSession stores the
+// authenticated *User.
+const userContextKey = "user"
+
+var errInvalidSession = errors.New("invalid or expired session")
+
+// newToken returns a random opaque token for the client and the hash of it
+// that is stored in the sessions or password_resets table. The plain token is
+// never persisted, so a leaked database does not leak usable tokens.
+func newToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession stores a new session for userID and returns the token to hand
+// to the client together with its expiry time.
+func (s *Server) createSession(ctx context.Context, userID int) (string, time.Time, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.SessionTTL)
+	if err := s.store.CreateSession(ctx, tokenHash, userID, now, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// userForSession resolves a session token to its user. Expired sessions are
+// removed on the way.
+func (s *Server) userForSession(ctx context.Context, token string) (*User, error) {
+	tokenHash := hashToken(token)
+
+	user, expiresAt, err := s.store.GetSessionUser(ctx, tokenHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(expiresAt) {
+		s.store.DeleteSession(ctx, tokenHash)
+		return nil, errInvalidSession
+	}
+	return &user, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// loadSession is a middleware that resolves the caller from the bearer token
+// and stores it in the request context. Requests without a token pass through
+// anonymously; requests with an invalid token are rejected.
+func (s *Server) loadSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := bearerToken(c)
+		if token == "" {
+			return next(c)
+		}
+
+		user, err := s.userForSession(c.Request().Context(), token)
+		if errors.Is(err, errInvalidSession) {
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"error": "Session is invalid or has expired",
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": "Failed to load session",
+			})
+		}
+
+		c.Set(userContextKey, user)
+		return next(c)
+	}
+}
+
+// requireAuth is a middleware that rejects requests without a valid session.
+// It must run after loadSession.
+func requireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if currentUser(c) == nil {
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"error": "Authentication required",
+			})
+		}
+		return next(c)
+	}
+}
+
+// currentUser returns the authenticated caller, or nil for anonymous requests.
+func currentUser(c echo.Context) *User {
+	user, _ := c.Get(userContextKey).(*User)
+	return user
+}
+
+// viewerID returns the ID of the caller, or 0 for anonymous requests.
+func viewerID(c echo.Context) int {
+	if user := currentUser(c); user != nil {
+		return user.IDUser
+	}
+	return 0
+}
+
+func (s *Server) Logout(c echo.Context) error {
+	err := s.store.DeleteSession(c.Request().Context(), hashToken(bearerToken(c)))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to end session",
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Logged out successfully",
+	})
+}
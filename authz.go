@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Role is the permission level of a user, stored in users.role.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func (r Role) valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// atLeast reports whether r grants every permission of other. Admins are
+// implicitly moderators. Unknown roles grant nothing.
+func (r Role) atLeast(other Role) bool {
+	rank, ok := roleRank[r]
+	return ok && rank >= roleRank[other]
+}
+
+// The policy functions below only look at their arguments, so they can be
+// exercised without a database or an HTTP request.
+
+// canModifyPost reports whether actor may edit or delete a post written by
+// authorID. Authors can change their own posts, moderators any post.
+func canModifyPost(actor *User, authorID int) bool {
+	if actor == nil {
+		return false
+	}
+	return actor.IDUser == authorID || actor.Role.atLeast(RoleModerator)
+}
+
+// canModifyUser reports whether actor may change the profile of targetID.
+// Users can change their own account, admins any account.
+func canModifyUser(actor *User, targetID int) bool {
+	if actor == nil {
+		return false
+	}
+	return actor.IDUser == targetID || actor.Role.atLeast(RoleAdmin)
+}
+
+// canAssignRoles reports whether actor may change the role of other users.
+func canAssignRoles(actor *User) bool {
+	return actor != nil && actor.Role.atLeast(RoleAdmin)
+}
+
+// canRestorePost reports whether actor may undo the deletion of a post by
+// authorID: the author or an admin. Moderators can delete posts but not bring
+// them back.
+func canRestorePost(actor *User, authorID int) bool {
+	if actor == nil {
+		return false
+	}
+	return actor.IDUser == authorID || actor.Role.atLeast(RoleAdmin)
+}
+
+// canViewRevisions reports whether actor may read the edit history of a post
+// by authorID. Earlier versions may hold text the author chose to remove, so
+// only the author and moderators get to see them.
+func canViewRevisions(actor *User, authorID int) bool {
+	return canModifyPost(actor, authorID)
+}
+
+// canModifyComment applies the same rule as canModifyPost.
+func canModifyComment(actor *User, authorID int) bool {
+	return canModifyPost(actor, authorID)
+}
+
+// canRestoreHiddenPost reports whether actor may bring back a post a
+// moderator hid. Authors cannot undo the decision; admins can.
+func canRestoreHiddenPost(actor *User) bool {
+	return actor != nil && actor.Role.atLeast(RoleAdmin)
+}
+
+// canModerate reports whether actor may work the report queue.
+func canModerate(actor *User) bool {
+	return actor != nil && actor.Role.atLeast(RoleModerator)
+}
+
+// canSuspendUser reports whether actor may suspend target. Moderators can
+// suspend users and admins can suspend moderators, but nobody can suspend
+// their peers or themselves.
+func canSuspendUser(actor *User, target User) bool {
+	if actor == nil || actor.IDUser == target.IDUser || !canModerate(actor) {
+		return false
+	}
+	return roleRank[actor.Role] > roleRank[target.Role]
+}
+
+// authorizePostChange looks up the post and writes the 404 or 403 response
+// if the caller may not modify it. It returns true if the handler can go on.
+func (s *Server) authorizePostChange(c echo.Context, postID int) (bool, error) {
+	post, err := s.store.GetPost(c.Request().Context(), postID)
+	if errors.Is(err, ErrNotFound) {
+		return false, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Post not found",
+		})
+	}
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to query post",
+		})
+	}
+
+	if !canModifyPost(currentUser(c), post.UserID) {
+		return false, c.JSON(http.StatusForbidden, echo.Map{
+			"error": "You are not allowed to modify this post",
+		})
+	}
+	return true, nil
+}
+
+// authorizeCommentChange is authorizePostChange for comments. Tombstones
+// count as not found.
+func (s *Server) authorizeCommentChange(c echo.Context, commentID int) (bool, error) {
+	comment, err := s.store.GetComment(c.Request().Context(), commentID)
+	if errors.Is(err, ErrNotFound) || err == nil && comment.Deleted {
+		return false, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Comment not found",
+		})
+	}
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to query comment",
+		})
+	}
+
+	if !canModifyComment(currentUser(c), comment.IDUser) {
+		return false, c.JSON(http.StatusForbidden, echo.Map{
+			"error": "You are not allowed to modify this comment",
+		})
+	}
+	return true, nil
+}
+
+// requireModerator is a middleware that rejects callers who are not
+// moderators. It must run after requireAuth.
+func requireModerator(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !canModerate(currentUser(c)) {
+			return c.JSON(http.StatusForbidden, echo.Map{
+				"error": "Only moderators can do this",
+			})
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) SetUserRole(c echo.Context) error {
+	type RoleRequest struct {
+		ID   int  `json:"id"`
+		Role Role `json:"role"`
+	}
+
+	var req RoleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if req.ID == 0 || !req.Role.valid() {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "User ID and a role of user, moderator or admin are required",
+		})
+	}
+
+	if !canAssignRoles(currentUser(c)) {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": "Only admins can change roles",
+		})
+	}
+
+	err := s.store.SetUserRole(c.Request().Context(), req.ID, req.Role)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to update role",
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Role updated successfully",
+	})
+}
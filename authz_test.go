@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+// The author of the content or target of the action in every case is
+// ownerID; "other" is a plain user acting on it.
+const ownerID = 1
+
+var (
+	owner        = &User{IDUser: ownerID, Role: RoleUser}
+	other        = &User{IDUser: 2, Role: RoleUser}
+	moderator    = &User{IDUser: 3, Role: RoleModerator}
+	admin        = &User{IDUser: 4, Role: RoleAdmin}
+	unknownRole  = &User{IDUser: 5, Role: "superuser"}
+	ownerAsAdmin = &User{IDUser: ownerID, Role: RoleAdmin}
+)
+
+type authzCase struct {
+	name  string
+	actor *User
+	want  bool
+}
+
+func checkAuthz(t *testing.T, policy string, cases []authzCase, allowed func(actor *User) bool) {
+	t.Helper()
+	for _, c := range cases {
+		if got := allowed(c.actor); got != c.want {
+			t.Errorf("%s by %s = %v, want %v", policy, c.name, got, c.want)
+		}
+	}
+}
+
+func TestCanModifyPost(t *testing.T) {
+	checkAuthz(t, "canModifyPost", []authzCase{
+		{"owner", owner, true},
+		{"other", other, false},
+		{"moderator", moderator, true},
+		{"admin", admin, true},
+		{"unknown role", unknownRole, false},
+		{"nil user", nil, false},
+	}, func(actor *User) bool { return canModifyPost(actor, ownerID) })
+}
+
+func TestCanModifyUser(t *testing.T) {
+	checkAuthz(t, "canModifyUser", []authzCase{
+		{"owner", owner, true},
+		{"other", other, false},
+		{"moderator", moderator, false},
+		{"admin", admin, true},
+		{"unknown role", unknownRole, false},
+		{"nil user", nil, false},
+	}, func(actor *User) bool { return canModifyUser(actor, ownerID) })
+}
+
+func TestCanAssignRoles(t *testing.T) {
+	checkAuthz(t, "canAssignRoles", []authzCase{
+		{"owner", owner, false},
+		{"other", other, false},
+		{"moderator", moderator, false},
+		{"admin", admin, true},
+		{"unknown role", unknownRole, false},
+		{"nil user", nil, false},
+	}, canAssignRoles)
+}
+
+func TestCanRestorePost(t *testing.T) {
+	checkAuthz(t, "canRestorePost", []authzCase{
+		{"owner", owner, true},
+		{"other", other, false},
+		{"moderator", moderator, false},
+		{"admin", admin, true},
+		{"unknown role", unknownRole, false},
+		{"nil user", nil, false},
+	}, func(actor *User) bool { return canRestorePost(actor, ownerID) })
+}
+
+func TestCanSuspendUser(t *testing.T) {
+	for _, target := range []struct {
+		name  string
+		user  *User
+		cases []authzCase
+	}{
+		{"user", owner, []authzCase{
+			{"self", owner, false},
+			{"other", other, false},
+			{"moderator", moderator, true},
+			{"admin", admin, true},
+			{"unknown role", unknownRole, false},
+			{"nil user", nil, false},
+		}},
+		{"moderator", moderator, []authzCase{
+			{"self", moderator, false},
+			{"other", other, false},
+			{"another moderator", &User{IDUser: 6, Role: RoleModerator}, false},
+			{"admin", admin, true},
+			{"nil user", nil, false},
+		}},
+		{"admin", ownerAsAdmin, []authzCase{
+			{"self", ownerAsAdmin, false},
+			{"moderator", moderator, false},
+			{"another admin", admin, false},
+			{"nil user", nil, false},
+		}},
+	} {
+		checkAuthz(t, "canSuspendUser of a "+target.name, target.cases,
+			func(actor *User) bool { return canSuspendUser(actor, *target.user) })
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStore keeps uploaded files under opaque keys such as
+// "3f/3fa9c2....jpg". Blobs are written once and never changed. The methods
+// follow the object model of S3 so that an S3-compatible bucket can take the
+// place of the local directory.
+type BlobStore interface {
+	// Put stores the content of r under key, replacing any blob there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get opens a blob. A missing key is reported as ErrNotFound. The caller
+	// closes the returned Blob.
+	Get(ctx context.Context, key string) (*Blob, error)
+	// Delete removes a blob; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is the address clients download the blob from.
+	URL(key string) string
+}
+
+// Blob is an open blob of a BlobStore.
+type Blob struct {
+	io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// localBlobStore is a BlobStore in a directory of the local filesystem. The
+// server itself serves the files, under GET /media/.
+type localBlobStore struct {
+	dir     string
+	baseURL string
+}
+
+func newLocalBlobStore(dir, baseURL string) *localBlobStore {
+	return &localBlobStore{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// path maps key to a file below dir. Keys that would escape it are rejected
+// as missing.
+func (s *localBlobStore) path(key string) (string, error) {
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file first, so that readers never see a
+// partial blob.
+func (s *localBlobStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (*Blob, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return &Blob{
+		ReadCloser:  f,
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) URL(key string) string {
+	return s.baseURL + "/" + key
+}
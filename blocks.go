@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Restriction is a way for a user to stop seeing another user. Both kinds
+// hide the posts and comments of the other user from lists and silence
+// their notifications.
+type Restriction string
+
+const (
+	// RestrictBlock also ends the follows between the two users and stops the
+	// blocked user from following, commenting on the posts and comments of,
+	// and messaging the blocker.
+	RestrictBlock Restriction = "block"
+	// RestrictMute only hides content, so the muted user cannot tell.
+	RestrictMute Restriction = "mute"
+)
+
+// restrictionPastTense is used in the messages of the endpoints.
+var restrictionPastTense = map[Restriction]string{
+	RestrictBlock: "blocked",
+	RestrictMute:  "muted",
+}
+
+// RestrictedUser is an entry of GET /blocks and GET /mutes.
+type RestrictedUser struct {
+	User
+	CreatedAt string `json:"created_at"`
+}
+
+func restrictedCursor(r RestrictedUser) Cursor { return Cursor{CreatedAt: r.CreatedAt, ID: r.IDUser} }
+
+// bindRestrictionTarget reads the {"userID": "..."} body of the block and
+// mute endpoints and writes a 400 response if it is invalid.
+func bindRestrictionTarget(c echo.Context, kind Restriction) (int, bool, error) {
+	type RestrictionRequest struct {
+		UserID string `json:"userID"`
+	}
+
+	var req RestrictionRequest
+	if err := c.Bind(&req); err != nil {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	userID, ok := parseID(req.UserID)
+	if !ok {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "User ID is required"})
+	}
+	if userID == currentUser(c).IDUser {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "You cannot " + string(kind) + " yourself"})
+	}
+	return userID, true, nil
+}
+
+// refuseBlocked writes a 403 response with message if any of userIDs has
+// blocked actorID. It returns true if the handler can go on.
+func (s *Server) refuseBlocked(c echo.Context, actorID int, message string, userIDs ...int) (bool, error) {
+	for _, userID := range userIDs {
+		blocked, err := s.store.Restricts(c.Request().Context(), userID, actorID, RestrictBlock)
+		if err != nil {
+			return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query blocks"})
+		}
+		if blocked {
+			return false, c.JSON(http.StatusForbidden, echo.Map{"error": message})
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) restrict(c echo.Context, kind Restriction) error {
+	userID, ok, err := bindRestrictionTarget(c, kind)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	callerID := currentUser(c).IDUser
+	err = s.store.Restrict(ctx, kind, callerID, userID, time.Now())
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to " + string(kind) + " user: " + err.Error()})
+	}
+	if kind == RestrictBlock {
+		s.timeline.unfollowed(ctx, callerID, userID)
+		s.timeline.unfollowed(ctx, userID, callerID)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "User " + restrictionPastTense[kind] + " successfully"})
+}
+
+func (s *Server) unrestrict(c echo.Context, kind Restriction) error {
+	userID, ok, err := bindRestrictionTarget(c, kind)
+	if !ok {
+		return err
+	}
+
+	err = s.store.Unrestrict(c.Request().Context(), kind, currentUser(c).IDUser, userID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "You have not " + restrictionPastTense[kind] + " this user"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to un" + string(kind) + " user: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "User un" + restrictionPastTense[kind] + " successfully"})
+}
+
+// listRestricted serves GET /blocks and GET /mutes: the users the caller
+// blocked or muted, most recent first. Nobody else can see these lists.
+func (s *Server) listRestricted(c echo.Context, kind Restriction) error {
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	users, err := s.store.ListRestricted(c.Request().Context(), kind, currentUser(c).IDUser, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query " + string(kind) + "s"})
+	}
+	return c.JSON(http.StatusOK, newPage(users, page, restrictedCursor))
+}
+
+func (s *Server) Block(c echo.Context) error     { return s.restrict(c, RestrictBlock) }
+func (s *Server) Unblock(c echo.Context) error   { return s.unrestrict(c, RestrictBlock) }
+func (s *Server) Mute(c echo.Context) error      { return s.restrict(c, RestrictMute) }
+func (s *Server) Unmute(c echo.Context) error    { return s.unrestrict(c, RestrictMute) }
+func (s *Server) GetBlocks(c echo.Context) error { return s.listRestricted(c, RestrictBlock) }
+func (s *Server) GetMutes(c echo.Context) error  { return s.listRestricted(c, RestrictMute) }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultThreadDepth is how many levels of replies tree responses include
+	// without ?depth=.
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+
+	// deletedCommentText replaces the text of tombstones.
+	deletedCommentText = "[deleted]"
+	// hiddenCommentText replaces the text of comments hidden by a moderator.
+	hiddenCommentText = "[removed by a moderator]"
+)
+
+// CommentNode is a comment with its replies, as returned by the tree mode of
+// GET /comments and by GET /comments/thread. Replies deeper than the
+// requested depth are left out, but still counted, so clients can tell that a
+// branch continues and fetch it with GET /comments/thread.
+type CommentNode struct {
+	Comment
+	// ReplyCount is the number of direct replies.
+	ReplyCount int `json:"replyCount"`
+	// DescendantCount is the number of replies in the whole branch.
+	DescendantCount int           `json:"descendantCount"`
+	Replies         []CommentNode `json:"replies"`
+}
+
+// buildCommentTrees nests replies under their roots, keeping depth levels of
+// replies. replies must contain every reply below roots.
+func buildCommentTrees(roots, replies []Comment, depth int) []CommentNode {
+	children := map[int][]Comment{}
+	for _, r := range replies {
+		children[*r.ParentID] = append(children[*r.ParentID], r)
+	}
+
+	var build func(c Comment, level int) CommentNode
+	build = func(c Comment, level int) CommentNode {
+		node := CommentNode{Comment: c, ReplyCount: len(children[c.IDComment]), Replies: []CommentNode{}}
+		for _, child := range children[c.IDComment] {
+			sub := build(child, level+1)
+			node.DescendantCount += 1 + sub.DescendantCount
+			if level < depth {
+				node.Replies = append(node.Replies, sub)
+			}
+		}
+		return node
+	}
+
+	nodes := make([]CommentNode, len(roots))
+	for i, root := range roots {
+		nodes[i] = build(root, 0)
+	}
+	return nodes
+}
+
+// parseThreadDepth reads ?depth= and writes a 400 response if it is invalid.
+func parseThreadDepth(c echo.Context) (int, bool, error) {
+	value := c.QueryParam("depth")
+	if value == "" {
+		return defaultThreadDepth, true, nil
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 0 || depth > maxThreadDepth {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "depth must be between 0 and " + strconv.Itoa(maxThreadDepth),
+		})
+	}
+	return depth, true, nil
+}
+
+// getCommentTree serves GET /comments?mode=tree: the page is made of
+// top-level comments, each with its nested replies.
+func (s *Server) getCommentTree(c echo.Context, postID int, page PageRequest) error {
+	depth, ok, err := parseThreadDepth(c)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	roots, err := s.store.ListRootComments(ctx, postID, viewerID(c), page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comments"})
+	}
+	rootPage := newPage(roots, page, commentCursor)
+
+	rootIDs := make([]int, len(rootPage.Items))
+	for i, root := range rootPage.Items {
+		rootIDs[i] = root.IDComment
+	}
+	replies, err := s.store.ListReplies(ctx, rootIDs, viewerID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comments"})
+	}
+
+	return c.JSON(http.StatusOK, Page[CommentNode]{
+		Items:      buildCommentTrees(rootPage.Items, replies, depth),
+		NextCursor: rootPage.NextCursor,
+	})
+}
+
+// GetCommentThread handles GET /comments/thread?id=&depth=: the comment with
+// its replies. The comment need not be top-level, which lets clients expand
+// branches cut off by the depth limit.
+func (s *Server) GetCommentThread(c echo.Context) error {
+	commentID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid comment ID"})
+	}
+	depth, ok, err := parseThreadDepth(c)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	root, err := s.store.GetComment(ctx, commentID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Comment not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comment"})
+	}
+	_, err = s.store.GetPost(ctx, root.IDPost)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Post not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
+	}
+	replies, err := s.store.ListReplies(ctx, []int{root.IDComment}, viewerID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comments"})
+	}
+
+	return c.JSON(http.StatusOK, buildCommentTrees([]Comment{root}, replies, depth)[0])
+}
+
+func (s *Server) EditComment(c echo.Context) error {
+	type EditRequest struct {
+		CommentID   string `json:"commentID"`
+		ContentText string `json:"contentText"`
+	}
+
+	var req EditRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	commentID, ok := parseID(req.CommentID)
+	if !ok || req.ContentText == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Comment ID and content text are required",
+		})
+	}
+
+	// Only the author or a moderator may edit the comment
+	if ok, err := s.authorizeCommentChange(c, commentID); !ok {
+		return err
+	}
+
+	err := s.store.UpdateCommentContent(c.Request().Context(), commentID, req.ContentText, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Comment not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to update comment: " + err.Error(),
+		})
+	}
+	// Mentions are made by the author, also when a moderator edits
+	comment, err := s.store.GetComment(c.Request().Context(), commentID)
+	if err != nil {
+		log.Printf("tags: look up edited comment %d: %v", commentID, err)
+	} else {
+		s.linkContent(c.Request().Context(), comment.IDUser, ContentRef{PostID: comment.IDPost, CommentID: commentID}, req.ContentText)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Comment updated successfully",
+	})
+}
+
+func (s *Server) DeleteComment(c echo.Context) error {
+	type DeleteRequest struct {
+		CommentID string `json:"commentID"`
+	}
+
+	var req DeleteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	commentID, ok := parseID(req.CommentID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Comment ID is required",
+		})
+	}
+
+	// Only the author or a moderator may delete the comment
+	if ok, err := s.authorizeCommentChange(c, commentID); !ok {
+		return err
+	}
+
+	err := s.store.DeleteComment(c.Request().Context(), commentID, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Comment not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to delete comment: " + err.Error(),
+		})
+	}
+	// Tombstones keep their row but lose their text; the links of a removed
+	// comment went with its row
+	comment, err := s.store.GetComment(c.Request().Context(), commentID)
+	if err == nil {
+		s.linkContent(c.Request().Context(), comment.IDUser, ContentRef{PostID: comment.IDPost, CommentID: commentID}, "")
+	} else if !errors.Is(err, ErrNotFound) {
+		log.Printf("tags: look up deleted comment %d: %v", commentID, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Comment deleted successfully",
+	})
+}
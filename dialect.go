@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect captures the differences between the SQL databases sqlStore can
+// run on. Queries are written once with "?" placeholders and SQLite-style
+// identifiers; the dialect adapts them where the databases disagree.
+type dialect interface {
+	name() string
+	// rebind rewrites the "?" placeholders of query into the native syntax.
+	rebind(query string) string
+	// uniqueViolation converts a UNIQUE constraint error into *ConflictError.
+	// Other errors are returned unchanged.
+	uniqueViolation(err error) error
+	// foreignKeyViolation reports whether err is a FOREIGN KEY constraint error.
+	foreignKeyViolation(err error) bool
+	// migrations is the schema history for this database.
+	migrations() []migration
+	// searchArm returns a SELECT of (kind, id, post_id, snippet, score) over
+	// the full-text index of t. Every placeholder takes the match argument.
+	// Higher scores are better matches. The arm ends with its WHERE clause
+	// and names the table of t as in searchAuthors, so that sqlStore.Search
+	// can add conditions on the author.
+	searchArm(t SearchType) string
+	// searchMatch converts the user's query into the match argument.
+	searchMatch(q string) string
+	// searchSnippet returns the function that turns the snippet column of
+	// the search arms for the query q into SearchResult.Snippet.
+	searchSnippet(q string) func(snippet string) string
+}
+
+// conflictFields maps the lowercased name of every UNIQUE column to the
+// field name used in API responses. Postgres folds unquoted identifiers to
+// lower case, so its errors report "displayname".
+var conflictFields = map[string]string{
+	"username":    "username",
+	"displayname": "displayName",
+	"email":       "email",
+}
+
+func conflictField(column string) string {
+	if field, ok := conflictFields[strings.ToLower(column)]; ok {
+		return field
+	}
+	return column
+}
+
+// sqliteDialect searches the FTS5 index if fts5 is set, and matches
+// substrings otherwise. go-sqlite3 only includes FTS5 with the sqlite_fts5 build tag.
+type sqliteDialect struct {
+	fts5 bool
+}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) migrations() []migration { return sqliteMigrations }
+
+// snippet arguments: match delimiters, ellipsis and the excerpt length in tokens.
+const sqliteSnippet = `'` + matchStart + `', '` + matchEnd + `', '…', 16`
+
+// bm25 is lower for better matches, hence the negation.
+var sqliteSearchArms = map[SearchType]string{
+	SearchPost: `SELECT 'post' AS kind, p.idPost AS id, p.idPost AS post_id,
+			snippet(posts_fts, 0, ` + sqliteSnippet + `) AS snippet, -bm25(posts_fts) AS score
+		FROM posts_fts JOIN posts p ON p.idPost = posts_fts.rowid
+		WHERE posts_fts MATCH ? AND p.deleted_at IS NULL`,
+	SearchComment: `SELECT 'comment' AS kind, c.idComment AS id, c.idPost AS post_id,
+			snippet(comments_fts, 0, ` + sqliteSnippet + `) AS snippet, -bm25(comments_fts) AS score
+		FROM comments_fts JOIN comments c ON c.idComment = comments_fts.rowid
+		JOIN posts p ON p.idPost = c.idPost
+		WHERE comments_fts MATCH ? AND p.deleted_at IS NULL`,
+	SearchUser: `SELECT 'user' AS kind, u.idUser AS id, 0 AS post_id,
+			snippet(users_fts, -1, ` + sqliteSnippet + `) AS snippet, -bm25(users_fts) AS score
+		FROM users_fts JOIN users u ON u.idUser = users_fts.rowid
+		WHERE users_fts MATCH ?`,
+}
+
+// Without FTS5, search works like the memory store: a text matches if it
+// contains every term, ignoring case, and scores the number of times they
+// occur. The match argument is a JSON array of the terms lowercased by
+// strings.ToLower, and the texts are lowercased by the same function (see
+// sqliteFold): SQLite's lower() only knows ASCII, so "Über" would never
+// match "über".
+func sqliteContainsTerms(text string) string {
+	return `NOT EXISTS (SELECT 1 FROM json_each(?) WHERE instr(fold(` + text + `), value) = 0)`
+}
+
+func sqliteCountTerms(text string) string {
+	return `(SELECT sum((length(fold(` + text + `)) - length(replace(fold(` + text + `), value, ''))) / length(value)) FROM json_each(?))`
+}
+
+var sqliteSubstringSearchArms = map[SearchType]string{
+	SearchPost: `SELECT 'post' AS kind, p.idPost AS id, p.idPost AS post_id,
+			p.content_text AS snippet, ` + sqliteCountTerms("p.content_text") + ` AS score
+		FROM posts p WHERE ` + sqliteContainsTerms("p.content_text") + ` AND p.deleted_at IS NULL`,
+	SearchComment: `SELECT 'comment' AS kind, c.idComment AS id, c.idPost AS post_id,
+			c.content_text AS snippet, ` + sqliteCountTerms("c.content_text") + ` AS score
+		FROM comments c JOIN posts p ON p.idPost = c.idPost
+		WHERE ` + sqliteContainsTerms("c.content_text") + ` AND p.deleted_at IS NULL`,
+	SearchUser: `SELECT 'user' AS kind, idUser AS id, 0 AS post_id,
+			username || ' ' || displayName AS snippet, ` + sqliteCountTerms("(username || ' ' || displayName)") + ` AS score
+		FROM users u WHERE ` + sqliteContainsTerms("(username || ' ' || displayName)"),
+}
+
+func (d sqliteDialect) searchArm(t SearchType) string {
+	if !d.fts5 {
+		return sqliteSubstringSearchArms[t]
+	}
+	return sqliteSearchArms[t]
+}
+
+// searchMatch quotes every word of q so that FTS5 operators and punctuation
+// typed by users are matched literally instead of failing to parse. The
+// quoted terms are implicitly ANDed.
+func (d sqliteDialect) searchMatch(q string) string {
+	if !d.fts5 {
+		match, _ := json.Marshal(strings.Fields(strings.ToLower(q)))
+		return string(match)
+	}
+	terms := strings.Fields(q)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchSnippet cuts and highlights the matched texts without FTS5, which
+// select them whole.
+func (d sqliteDialect) searchSnippet(q string) func(string) string {
+	if d.fts5 {
+		return highlightSnippet
+	}
+	m := newTextMatcher(q)
+	return func(text string) string {
+		snippet, _, _ := m.match(text)
+		return snippet
+	}
+}
+
+// uniqueViolation parses "UNIQUE constraint failed: users.email".
+func (sqliteDialect) uniqueViolation(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return err
+	}
+	msg := sqliteErr.Error()
+	i := strings.LastIndex(msg, ".")
+	if i < 0 {
+		return err
+	}
+	return &ConflictError{Field: conflictField(msg[i+1:])}
+}
+
+func (sqliteDialect) foreignKeyViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+// rebind numbers the placeholders: "a = ? AND b = ?" becomes "a = $1 AND b = $2".
+// None of our queries contain a literal question mark.
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) migrations() []migration { return postgresMigrations }
+
+const postgresHeadline = `'StartSel=` + matchStart + `, StopSel=` + matchEnd + `, MaxWords=16, MinWords=4'`
+
+// The search columns are generated tsvectors, see postgresMigrations.
+var postgresSearchArms = map[SearchType]string{
+	SearchPost: `SELECT 'post' AS kind, p.idPost AS id, p.idPost AS post_id,
+			ts_headline('simple', p.content_text, q, ` + postgresHeadline + `) AS snippet,
+			ts_rank(p.search, q) AS score
+		FROM posts p, plainto_tsquery('simple', ?) q WHERE p.search @@ q AND p.deleted_at IS NULL`,
+	SearchComment: `SELECT 'comment' AS kind, c.idComment AS id, c.idPost AS post_id,
+			ts_headline('simple', c.content_text, q, ` + postgresHeadline + `) AS snippet,
+			ts_rank(c.search, q) AS score
+		FROM comments c JOIN posts p ON p.idPost = c.idPost, plainto_tsquery('simple', ?) q
+		WHERE c.search @@ q AND p.deleted_at IS NULL`,
+	SearchUser: `SELECT 'user' AS kind, u.idUser AS id, 0 AS post_id,
+			ts_headline('simple', u.username || ' ' || u.displayName, q, ` + postgresHeadline + `) AS snippet,
+			ts_rank(u.search, q) AS score
+		FROM users u, plainto_tsquery('simple', ?) q WHERE u.search @@ q`,
+}
+
+func (postgresDialect) searchArm(t SearchType) string { return postgresSearchArms[t] }
+
+// searchMatch passes q through; plainto_tsquery already ignores operators.
+func (postgresDialect) searchMatch(q string) string { return q }
+
+func (postgresDialect) searchSnippet(q string) func(string) string { return highlightSnippet }
+
+// pqKeyDetail matches the detail of a unique_violation, e.g.
+// "Key (email)=(someone@example.com) already exists."
+var pqKeyDetail = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+func (postgresDialect) uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+	m := pqKeyDetail.FindStringSubmatch(pqErr.Detail)
+	if m == nil {
+		return err
+	}
+	return &ConflictError{Field: conflictField(m[1])}
+}
+
+func (postgresDialect) foreignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
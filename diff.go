@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DiffOp is the kind of a DiffChange.
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffChange is a run of text that both versions share, or that only the
+// new or only the old version contains. Concatenating the equal and delete
+// runs gives the old text, the equal and insert runs the new one.
+type DiffChange struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffCells bounds the memory of the LCS table. Texts whose differing
+// middle parts are larger are diffed as a whole replacement.
+const maxDiffCells = 4_000_000
+
+var wordTokens = regexp.MustCompile(`\s+|\S+`)
+
+// splitWords splits s into words and the whitespace between them.
+func splitWords(s string) []string {
+	return wordTokens.FindAllString(s, -1)
+}
+
+// splitLines splits s into lines, each keeping its trailing newline.
+func splitLines(s string) []string {
+	return strings.SplitAfter(s, "\n")
+}
+
+// diffTokens computes a minimal diff of two token lists with the classic
+// longest-common-subsequence table, after trimming the common prefix and
+// suffix. Adjacent tokens with the same op are merged.
+func diffTokens(a, b []string) []DiffChange {
+	var changes []DiffChange
+	add := func(op DiffOp, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(changes); n > 0 && changes[n-1].Op == op {
+			changes[n-1].Text += text
+			return
+		}
+		changes = append(changes, DiffChange{Op: op, Text: text})
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	add(DiffEqual, strings.Join(a[:prefix], ""))
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if len(midA)*len(midB) > maxDiffCells {
+		add(DiffDelete, strings.Join(midA, ""))
+		add(DiffInsert, strings.Join(midB, ""))
+	} else {
+		// lcs[i][j] is the LCS length of midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(midA) && j < len(midB) {
+			switch {
+			case midA[i] == midB[j]:
+				add(DiffEqual, midA[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				add(DiffDelete, midA[i])
+				i++
+			default:
+				add(DiffInsert, midB[j])
+				j++
+			}
+		}
+		add(DiffDelete, strings.Join(midA[i:], ""))
+		add(DiffInsert, strings.Join(midB[j:], ""))
+	}
+
+	add(DiffEqual, strings.Join(a[len(a)-suffix:], ""))
+	if changes == nil {
+		changes = []DiffChange{}
+	}
+	return changes
+}
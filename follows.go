@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FollowUser is an entry of a follower or following list.
+type FollowUser struct {
+	User
+	FollowedAt string `json:"followed_at"`
+}
+
+func followCursor(f FollowUser) Cursor { return Cursor{CreatedAt: f.FollowedAt, ID: f.IDUser} }
+
+// UserProfile is the response of GET /user.
+type UserProfile struct {
+	User
+	Followers int `json:"followers"`
+	Following int `json:"following"`
+}
+
+// FollowPage is the response of the follower and following lists: one page
+// plus the size of the whole list.
+type FollowPage struct {
+	Page[FollowUser]
+	Total int `json:"total"`
+}
+
+// bindFollowTarget reads the {"userID": "..."} body of the follow endpoints
+// and writes a 400 response if it is invalid.
+func bindFollowTarget(c echo.Context) (int, bool, error) {
+	type FollowRequest struct {
+		UserID string `json:"userID"`
+	}
+
+	var req FollowRequest
+	if err := c.Bind(&req); err != nil {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	userID, ok := parseID(req.UserID)
+	if !ok {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "User ID is required"})
+	}
+	if userID == currentUser(c).IDUser {
+		return 0, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "You cannot follow yourself"})
+	}
+	return userID, true, nil
+}
+
+func (s *Server) Follow(c echo.Context) error {
+	userID, ok, err := bindFollowTarget(c)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	follower := currentUser(c).IDUser
+	if ok, err := s.refuseBlocked(c, follower, "You cannot follow this user", userID); !ok {
+		return err
+	}
+	blocking, err := s.store.Restricts(ctx, follower, userID, RestrictBlock)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query blocks"})
+	}
+	if blocking {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Unblock this user to follow them"})
+	}
+	err = s.store.Follow(ctx, follower, userID, time.Now())
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to follow user: " + err.Error()})
+	}
+	s.timeline.followed(ctx, follower, userID)
+	s.notify(ctx, NotificationEvent{UserID: userID, Kind: NotifyFollow, ActorID: follower})
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "User followed successfully"})
+}
+
+func (s *Server) Unfollow(c echo.Context) error {
+	userID, ok, err := bindFollowTarget(c)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	follower := currentUser(c).IDUser
+	err = s.store.Unfollow(ctx, follower, userID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "You are not following this user"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to unfollow user: " + err.Error()})
+	}
+	s.timeline.unfollowed(ctx, follower, userID)
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "User unfollowed successfully"})
+}
+
+// listFollows serves GET /followers and GET /following for the user ?id=.
+func (s *Server) listFollows(c echo.Context, followers bool) error {
+	userID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid user ID"})
+	}
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.store.GetUser(ctx, userID); errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
+	}
+
+	list, total := s.store.ListFollowing, 0
+	if followers {
+		list = s.store.ListFollowers
+	}
+	users, err := list(ctx, userID, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query follows"})
+	}
+	followerCount, followingCount, err := s.store.FollowCounts(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query follows"})
+	}
+	total = followingCount
+	if followers {
+		total = followerCount
+	}
+
+	return c.JSON(http.StatusOK, FollowPage{Page: newPage(users, page, followCursor), Total: total})
+}
+
+func (s *Server) GetFollowers(c echo.Context) error {
+	return s.listFollows(c, true)
+}
+
+func (s *Server) GetFollowing(c echo.Context) error {
+	return s.listFollows(c, false)
+}
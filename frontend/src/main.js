@@ -33,7 +33,8 @@ const store = createStore({
     state() {
         return {
             userId: -1,
-            currentUser: null
+            currentUser: null,
+            token: null
         }
     },
     mutations: {
@@ -43,9 +44,15 @@ const store = createStore({
         setCurrentUser(state, user) {
             state.currentUser = user
         },
+        setToken(state, token) {
+            state.token = token
+            axios.defaults.headers.common['Authorization'] = 'Bearer ' + token
+        },
         logout(state) {
             state.currentUser = null
             state.userId = -1
+            state.token = null
+            delete axios.defaults.headers.common['Authorization']
         }
     },
     getters: {
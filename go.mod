@@ -1,11 +1,14 @@
-module main
+module praxprojekt
 
 go 1.22.2
 
 require (
 	github.com/go-faker/faker/v4 v4.5.0
 	github.com/labstack/echo/v4 v4.12.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.23
+	golang.org/x/crypto v0.22.0
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -15,7 +18,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// Stream topics. Clients subscribe to topics when they connect to GET
+// /stream; the user topic of the caller is subscribed implicitly.
+const (
+	// topicPosts carries every new, edited, deleted and restored post.
+	topicPosts = "posts"
+)
+
+// topicPost carries the comments of one post and the changes to it.
+func topicPost(postID int) string { return "post:" + strconv.Itoa(postID) }
+
+// topicUser carries the notifications and direct messages of one user.
+func topicUser(userID int) string { return "user:" + strconv.Itoa(userID) }
+
+// Stream event types.
+const (
+	EventPostCreated    = "post.created"
+	EventPostUpdated    = "post.updated"
+	EventPostDeleted    = "post.deleted"
+	EventPostRestored   = "post.restored"
+	EventCommentCreated = "comment.created"
+	EventNotification   = "notification"
+	EventMessageCreated = "message.created"
+	// EventConversationRead carries a ReadReceipt.
+	EventConversationRead = "conversation.read"
+	// EventReset tells a reconnecting client that events it missed are no
+	// longer available, so it has to refetch instead.
+	EventReset = "stream.reset"
+)
+
+// streamHistory is how many recent events the hub keeps for clients that
+// reconnect with Last-Event-ID.
+const streamHistory = 1024
+
+// StreamEvent is one message of the stream. IDs increase by one per event,
+// across all topics.
+type StreamEvent struct {
+	ID   uint64
+	Type string
+	Data json.RawMessage
+	// AuthorID is the author of the post or comment the event carries, or 0.
+	// Stream leaves out the events of authors the subscriber restricted.
+	AuthorID int
+	topics   []string
+}
+
+// Hub is an in-process publish/subscribe hub. Publishing never blocks: each
+// subscription has a bounded buffer, and a subscriber that falls so far
+// behind that its buffer is full is disconnected. It can reconnect and
+// catch up from the history.
+type Hub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+	// history holds the last streamHistory events, oldest first.
+	history    []StreamEvent
+	bufferSize int
+}
+
+// Subscription is the receiving end of one stream connection. Events is
+// closed when the hub drops the subscription for falling behind.
+type Subscription struct {
+	Events <-chan StreamEvent
+	events chan StreamEvent
+	topics map[string]bool
+}
+
+func NewHub(bufferSize int) *Hub {
+	return &Hub{subscribers: map[*Subscription]struct{}{}, bufferSize: bufferSize}
+}
+
+func (s *Subscription) wants(e StreamEvent) bool {
+	for _, topic := range e.topics {
+		if s.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe registers a subscription to topics. If lastID is not 0, the
+// events after it that are still in the history are queued first; an
+// EventReset is queued instead if some of them are gone.
+func (h *Hub) Subscribe(topics []string, lastID uint64) *Subscription {
+	sub := &Subscription{topics: map[string]bool{}}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []StreamEvent
+	if lastID != 0 && lastID != h.lastID {
+		// An ID from the future was issued before a restart
+		if lastID > h.lastID || len(h.history) == 0 || h.history[0].ID > lastID+1 {
+			missed = []StreamEvent{{ID: h.lastID, Type: EventReset, Data: json.RawMessage("{}")}}
+		} else {
+			for _, e := range h.history {
+				if e.ID > lastID && sub.wants(e) {
+					missed = append(missed, e)
+				}
+			}
+		}
+	}
+
+	sub.events = make(chan StreamEvent, max(h.bufferSize, len(missed)))
+	sub.Events = sub.events
+	for _, e := range missed {
+		sub.events <- e
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a subscription. It is safe to call after the hub
+// dropped it.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish sends an event to the subscribers of any of the topics. Each
+// subscriber gets it at most once.
+func (h *Hub) Publish(eventType string, data any, topics ...string) {
+	h.PublishContent(eventType, 0, data, topics...)
+}
+
+// PublishContent is Publish for events that carry content by authorID.
+func (h *Hub) PublishContent(eventType string, authorID int, data any, topics ...string) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("hub: encode %s: %v", eventType, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	e := StreamEvent{ID: h.lastID, Type: eventType, Data: payload, AuthorID: authorID, topics: topics}
+	if len(h.history) == streamHistory {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, e)
+
+	for sub := range h.subscribers {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			// Too slow to keep up; it reconnects and catches up from the history
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the emails of the server. Send returns once the email is
+// handed off, which does not mean it arrived.
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+// newMailer returns the Mailer configured by cfg: SMTP if SMTPAddr is set,
+// else files in MailDir or the log.
+func newMailer(cfg Config) Mailer {
+	if cfg.SMTPAddr != "" {
+		return newSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	}
+	return &fileMailer{dir: cfg.MailDir, from: cfg.MailFrom}
+}
+
+// formatMail renders m as a message from the given sender. Headers with line
+// breaks are refused, so a crafted address cannot add headers of its own.
+func formatMail(from string, m Mail, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+// smtpMailer sends emails through an SMTP server, with STARTTLS when the
+// server offers it.
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newSMTPMailer(addr, username, password, from string) *smtpMailer {
+	m := &smtpMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send ignores ctx: net/smtp has no way to cancel a delivery.
+func (m *smtpMailer) Send(ctx context.Context, mail Mail) error {
+	msg, err := formatMail(m.from, mail, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{mail.To}, msg)
+}
+
+// fileMailer writes every email to a .eml file in dir, or to the log if dir
+// is empty. It is meant for local development and tests.
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m *fileMailer) Send(ctx context.Context, mail Mail) error {
+	now := time.Now()
+	msg, err := formatMail(m.from, mail, now)
+	if err != nil {
+		return err
+	}
+	if m.dir == "" {
+		log.Printf("mail to %s:\n%s", mail.To, msg)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(m.dir, now.UTC().Format("20060102-150405-")+"*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(msg); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
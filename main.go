@@ -1,24 +1,25 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-faker/faker/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-
 const (
-	testing = true
-	testingLogin = "test"
+	testingMode     = true
+	testingLogin    = "test"
 	testingPassword = "test"
 )
 
@@ -27,7 +28,8 @@ type User struct {
 	Username    string `json:"username"`
 	DisplayName string `json:"displayName"`
 	Email       string `json:"email"`
-	Password    string `json:"password"`
+	Password    string `json:"-"`
+	Role        Role   `json:"role"`
 }
 
 type Post struct {
@@ -35,229 +37,239 @@ type Post struct {
 	ContentText string `json:"content_text"`
 	CreatedAt   string `json:"created_at"`
 	UserID      int    `json:"userID"`
+	// DeletedAt is set on soft-deleted posts, which only the restore
+	// endpoint ever sees.
+	DeletedAt *string `json:"deleted_at,omitempty"`
+	// HiddenAt is set on posts a moderator hid, which are also soft-deleted
+	// but never purged.
+	HiddenAt *string `json:"hidden_at,omitempty"`
+	// Reactions counts the reactions by type and Reacted lists the types
+	// the caller reacted with. Only GET /posts and GET /post fill them in.
+	Reactions map[string]int `json:"reactions,omitempty"`
+	Reacted   []string       `json:"reacted,omitempty"`
+	// Attachments are the images of the post in upload order. Post lists
+	// and GET /post fill them in.
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
 type Comment struct {
-	IDComment   int    `json:"idComment"`
-	IDPost      int    `json:"idPost"`
+	IDComment int `json:"idComment"`
+	IDPost    int `json:"idPost"`
+	// ParentID is the comment this one replies to, nil for top-level comments.
+	ParentID    *int   `json:"parentCommentID"`
 	IDUser      int    `json:"idUser"`
 	ContentText string `json:"content_text"`
 	CreatedAt   string `json:"created_at"`
+	// EditedAt is when the text was last changed, nil if never.
+	EditedAt *string `json:"edited_at"`
+	// Deleted marks a tombstone: a deleted comment kept because it has
+	// replies. Its text is replaced by deletedCommentText.
+	Deleted bool `json:"deleted"`
+	// Hidden marks a tombstone left by a moderator, which is kept even
+	// without replies. Its text is replaced by hiddenCommentText.
+	Hidden bool `json:"hidden"`
 }
 
-func GetAllPosts(c echo.Context) error {
-	// Get all posts from the database
-	query := `SELECT idPost, content_text, created_at, userID FROM posts`
-	rows, err := db.Query(query)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query posts"})
-	}
-	defer rows.Close()
+// parseID parses a numeric ID from a query parameter or request body field.
+func parseID(value string) (int, bool) {
+	id, err := strconv.Atoi(value)
+	return id, err == nil && id > 0
+}
 
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.IDPost, &post.ContentText, &post.CreatedAt, &post.UserID); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan post data"})
-		}
-		posts = append(posts, post)
-	}
-	if err := rows.Err(); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error iterating over rows"})
-	}
+// referenceMessages describes every *ReferenceError field for API responses.
+var referenceMessages = map[string]string{
+	"userID":          "User does not exist",
+	"postID":          "Post does not exist",
+	"parentCommentID": "Parent comment does not exist",
+	"commentID":       "Comment does not exist",
+}
 
-	// Return posts as JSON response
-	return c.JSON(http.StatusOK, posts)
+// unprocessableReference writes the 422 response for a write that refers to
+// a missing row.
+func unprocessableReference(c echo.Context, ref *ReferenceError) error {
+	return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+		"error": referenceMessages[ref.Field],
+		"field": ref.Field,
+	})
 }
 
-func GetUsers() []User {
-	// Get all users from the database
-	query := `SELECT idUser, username, displayName, email FROM users`
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Fatal(err)
+func (s *Server) GetAllPosts(c echo.Context) error {
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
 	}
-	defer rows.Close()
 
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.IDUser, &user.Username, &user.DisplayName, &user.Email); err != nil {
-			log.Fatal(err)
-		}
-		users = append(users, user)
+	// Get one page of posts from the store
+	posts, err := s.store.ListPosts(c.Request().Context(), viewerID(c), page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query posts"})
 	}
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+	postPage := newPage(posts, page, postCursor)
+	if err := s.withReactions(c, postPage.Items); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query reactions"})
+	}
+	if err := s.withAttachments(c.Request().Context(), postPage.Items); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
 	}
 
-	return users
+	// Return posts as JSON response
+	return c.JSON(http.StatusOK, postPage)
 }
 
-func GetPosts() []Post {
-	// Get all posts from the database
-	query := `SELECT idPost, content_text, created_at, userID FROM posts`
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Fatal(err)
+func (s *Server) GetPostByUserID(c echo.Context) error {
+	userID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid user ID"})
 	}
-	defer rows.Close()
 
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.IDPost, &post.ContentText, &post.CreatedAt, &post.UserID); err != nil {
-			log.Fatal(err)
-		}
-		posts = append(posts, post)
-	}
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
 	}
 
-	return posts
-}
-
-func GetPostByUserID(c echo.Context) error {
-	userID := c.QueryParam("id")
-
-	// Get all posts from the database
-	query := `SELECT idPost, content_text, created_at, userID FROM posts WHERE userID = ?`
-	rows, err := db.Query(query, userID)
+	// Get one page of the user's posts from the store
+	posts, err := s.store.ListPostsByUser(c.Request().Context(), userID, viewerID(c), page.probe())
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query posts"})
 	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.IDPost, &post.ContentText, &post.CreatedAt, &post.UserID); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan post data"})
-		}
-		posts = append(posts, post)
-	}
-	if err := rows.Err(); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error iterating over rows"})
+	postPage := newPage(posts, page, postCursor)
+	if err := s.withAttachments(c.Request().Context(), postPage.Items); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
 	}
 
 	// Return posts as JSON response
-	return c.JSON(http.StatusOK, posts)
+	return c.JSON(http.StatusOK, postPage)
 }
 
-func GetAllCommentsToPost(c echo.Context) error {
-	postID := c.QueryParam("idPost")
+func (s *Server) GetAllCommentsToPost(c echo.Context) error {
+	postID, ok := parseID(c.QueryParam("idPost"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid post ID"})
+	}
+
+	page, ok, err := s.parsePageRequest(c, SortOldest)
+	if !ok {
+		return err
+	}
 
-	// Get all comments from the database
-	query := `SELECT idComment, idPost, idUser, content_text, created_at FROM comments WHERE idPost = ?`
-	rows, err := db.Query(query, postID)
+	// The comments of a soft-deleted post go with it
+	_, err = s.store.GetPost(c.Request().Context(), postID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Post not found"})
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comments"})
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
 	}
-	defer rows.Close()
 
-	var comments []Comment
-	for rows.Next() {
-		var comment Comment
-		if err := rows.Scan(&comment.IDComment, &comment.IDPost, &comment.IDUser, &comment.ContentText, &comment.CreatedAt); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan comment data"})
-		}
-		comments = append(comments, comment)
+	switch c.QueryParam("mode") {
+	case "", "flat":
+	case "tree":
+		return s.getCommentTree(c, postID, page)
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "mode must be flat or tree"})
 	}
-	if err := rows.Err(); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error iterating over rows"})
+
+	// Get one page of the post's comments from the store
+	comments, err := s.store.ListCommentsByPost(c.Request().Context(), postID, viewerID(c), page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comments"})
 	}
 
 	// Return comments as JSON response
-	return c.JSON(http.StatusOK, comments)
+	return c.JSON(http.StatusOK, newPage(comments, page, commentCursor))
 }
 
-func GetUserByID(c echo.Context) error {
-	userID := c.QueryParam("id")
-
-	// Get user from the database
-	query := `SELECT idUser, username, displayName, email FROM users WHERE idUser = ?`
-	row := db.QueryRow(query, userID)
+func (s *Server) GetUserByID(c echo.Context) error {
+	userID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid user ID"})
+	}
 
-	var user User
-	if err := row.Scan(&user.IDUser, &user.Username, &user.DisplayName, &user.Email); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan user data"})
+	// Get user from the store
+	ctx := c.Request().Context()
+	user, err := s.store.GetUser(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
+	}
+	followers, following, err := s.store.FollowCounts(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
 	}
 
-	// Return user as JSON response
-	return c.JSON(http.StatusOK, user)
+	// Return user with follow counts as JSON response
+	return c.JSON(http.StatusOK, UserProfile{User: user, Followers: followers, Following: following})
 }
 
-func GetAllUsers(c echo.Context) error {
-	// Get all users from the database
-	query := `SELECT idUser, username, displayName, email FROM users`
-	rows, err := db.Query(query)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query users"})
+func (s *Server) GetAllUsers(c echo.Context) error {
+	page, ok, err := s.parsePageRequest(c, SortOldest)
+	if !ok {
+		return err
 	}
-	defer rows.Close()
 
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.IDUser, &user.Username, &user.DisplayName, &user.Email); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan user data"})
-		}
-		users = append(users, user)
-	}
-	if err := rows.Err(); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error iterating over rows"})
+	// Get one page of users from the store
+	users, err := s.store.ListUsers(c.Request().Context(), page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query users"})
 	}
 
 	// Return users as JSON response
-	return c.JSON(http.StatusOK, users)
+	return c.JSON(http.StatusOK, newPage(users, page, userCursor))
 }
 
-func AddPost(c echo.Context) error {
-    type PostRequest struct {
-        UserID      string `json:"userID"`
-        ContentText string `json:"contentText"`
-    }
+func (s *Server) AddPost(c echo.Context) error {
+	type PostRequest struct {
+		ContentText string `json:"contentText"`
+	}
 
-    post := new(PostRequest)
-    if err := c.Bind(post); err != nil {
+	post := new(PostRequest)
+	if err := c.Bind(post); err != nil {
 		fmt.Println(err)
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Invalid request data",
-        })
-    }
-
-    if post.UserID == "" || post.ContentText == "" {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "UserID and content text are required",
-        })
-    }
-
-    query := `INSERT INTO posts (userID, content_text, created_at) VALUES (?, ?, ?)`
-    result, err := db.Exec(query, post.UserID, post.ContentText, time.Now().Format(time.RFC3339))
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to insert post: " + err.Error(),
-        })
-    }
-
-    rowsAffected, err := result.RowsAffected()
-    if err != nil || rowsAffected == 0 {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to confirm post insertion",
-        })
-    }
-
-    return c.JSON(http.StatusOK, echo.Map{
-        "message": "Post added successfully",
-    })
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request data",
+		})
+	}
+
+	if post.ContentText == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Content text is required",
+		})
+	}
+
+	// The author is always the authenticated caller
+	created := &Post{
+		ContentText: post.ContentText,
+		CreatedAt:   formatTime(time.Now()),
+		UserID:      currentUser(c).IDUser,
+	}
+	err := s.store.CreatePost(c.Request().Context(), created)
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to insert post: " + err.Error(),
+		})
+	}
+	s.timeline.posted(c.Request().Context(), created.IDPost)
+	s.linkContent(c.Request().Context(), created.UserID, ContentRef{PostID: created.IDPost}, created.ContentText)
+	s.hub.PublishContent(EventPostCreated, created.UserID, created, topicPosts)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Post added successfully",
+		"idPost":  created.IDPost,
+	})
 }
 
-func AddComment(c echo.Context) error {
+func (s *Server) AddComment(c echo.Context) error {
 	type CommentRequest struct {
-		PostID      string `json:"postID"`
-		UserID      string `json:"userID"`
-		ContentText string `json:"contentText"`
+		PostID string `json:"postID"`
+		// ParentCommentID is optional and makes the comment a reply.
+		ParentCommentID string `json:"parentCommentID"`
+		ContentText     string `json:"contentText"`
 	}
 
 	comment := new(CommentRequest)
@@ -269,28 +281,73 @@ func AddComment(c echo.Context) error {
 	}
 
 	// Validate input
-	if comment.PostID == "" || comment.UserID == "" || comment.ContentText == "" {
+	postID, ok := parseID(comment.PostID)
+	if !ok || comment.ContentText == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "PostID, userID, and content text are required",
+			"error": "PostID and content text are required",
 		})
 	}
 
-	// Insert comment into the database
-	query := `INSERT INTO comments (idPost, idUser, content_text, created_at) VALUES (?, ?, ?, ?)`
-	result, err := db.Exec(query, comment.PostID, comment.UserID, comment.ContentText, time.Now().Format(time.RFC3339))
+	// Soft-deleted posts still satisfy the foreign key, so check explicitly
+	post, err := s.store.GetPost(c.Request().Context(), postID)
+	if errors.Is(err, ErrNotFound) {
+		return unprocessableReference(c, &ReferenceError{Field: "postID"})
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": "Failed to insert comment: " + err.Error(),
-		})
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
 	}
 
-	// Check if the insert was successful
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
+	var parent *Comment
+	if comment.ParentCommentID != "" {
+		id, ok := parseID(comment.ParentCommentID)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid parent comment ID"})
+		}
+		found, err := s.store.GetComment(c.Request().Context(), id)
+		if errors.Is(err, ErrNotFound) {
+			return unprocessableReference(c, &ReferenceError{Field: "parentCommentID"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query comment"})
+		}
+		if found.IDPost != postID {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Parent comment belongs to another post"})
+		}
+		parent = &found
+	}
+
+	// Users who blocked the caller are not to be replied to
+	authorIDs := []int{post.UserID}
+	if parent != nil {
+		authorIDs = append(authorIDs, parent.IDUser)
+	}
+	if ok, err := s.refuseBlocked(c, currentUser(c).IDUser, "You cannot comment on this post", authorIDs...); !ok {
+		return err
+	}
+
+	// Insert comment into the store, authored by the caller
+	created := &Comment{
+		IDPost:      postID,
+		IDUser:      currentUser(c).IDUser,
+		ContentText: comment.ContentText,
+		CreatedAt:   formatTime(time.Now()),
+	}
+	if parent != nil {
+		created.ParentID = &parent.IDComment
+	}
+	err = s.store.CreateComment(c.Request().Context(), created)
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": "Failed to confirm comment insertion",
+			"error": "Failed to insert comment: " + err.Error(),
 		})
 	}
+	s.notifyComment(c.Request().Context(), post, parent, *created)
+	s.linkContent(c.Request().Context(), created.IDUser, ContentRef{PostID: postID, CommentID: created.IDComment}, created.ContentText)
+	s.hub.PublishContent(EventCommentCreated, created.IDUser, created, topicPost(postID))
 
 	// Return success response
 	return c.JSON(http.StatusOK, echo.Map{
@@ -298,201 +355,208 @@ func AddComment(c echo.Context) error {
 	})
 }
 
+func (s *Server) EditPost(c echo.Context) error {
+	// Create struct for request body
+	type EditRequest struct {
+		PostID      string `json:"postID"`
+		ContentText string `json:"contentText"`
+	}
 
-// In main.go, update the EditPost function:
-func EditPost(c echo.Context) error {
-    // Create struct for request body
-    type EditRequest struct {
-        PostID      string `json:"postID"`
-        ContentText string `json:"contentText"`
-    }
-
-    // Parse request body
-    var req EditRequest
-    if err := c.Bind(&req); err != nil {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Invalid request format",
-        })
-    }
-
-    // Validate input
-    if req.PostID == "" || req.ContentText == "" {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Post ID and content text are required",
-        })
-    }
-
-    // Update post in database
-    query := `UPDATE posts SET content_text = ? WHERE idPost = ?`
-    result, err := db.Exec(query, req.ContentText, req.PostID)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to update post: " + err.Error(),
-        })
-    }
-
-    // Verify update
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to confirm update",
-        })
-    }
-    if rowsAffected == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "error": "Post not found",
-        })
-    }
-
-    return c.JSON(http.StatusOK, echo.Map{
-        "message": "Post updated successfully",
-    })
-}
+	// Parse request body
+	var req EditRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	// Validate input
+	postID, ok := parseID(req.PostID)
+	if !ok || req.ContentText == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Post ID and content text are required",
+		})
+	}
+
+	// Only the author or a moderator may edit the post
+	if ok, err := s.authorizePostChange(c, postID); !ok {
+		return err
+	}
 
-// In main.go, update the DeletePost function:
-func DeletePost(c echo.Context) error {
-    // Create struct for request body
-    type DeleteRequest struct {
-        PostID string `json:"postID"`
-    }
-
-    // Parse request body
-    var req DeleteRequest
-    if err := c.Bind(&req); err != nil {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Invalid request format",
-        })
-    }
-
-    // Validate postID
-    if req.PostID == "" {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Post ID is required",
-        })
-    }
-
-    // Delete post from database
-    query := `DELETE FROM posts WHERE idPost = ?`
-    result, err := db.Exec(query, req.PostID)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to delete post: " + err.Error(),
-        })
-    }
-
-    // Verify deletion
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to confirm deletion",
-        })
-    }
-    if rowsAffected == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "error": "Post not found",
-        })
-    }
-
-    return c.JSON(http.StatusOK, echo.Map{
-        "message": "Post deleted successfully",
-    })
+	// Update post in the store
+	err := s.store.UpdatePostContent(c.Request().Context(), postID, req.ContentText, currentUser(c).IDUser, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Post not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to update post: " + err.Error(),
+		})
+	}
+	// Mentions are made by the author, also when a moderator edits
+	post, err := s.store.GetPost(c.Request().Context(), postID)
+	if err != nil {
+		log.Printf("tags: look up edited post %d: %v", postID, err)
+	} else {
+		s.linkContent(c.Request().Context(), post.UserID, ContentRef{PostID: postID}, req.ContentText)
+	}
+	s.publishPost(c.Request().Context(), EventPostUpdated, postID)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Post updated successfully",
+	})
 }
 
-func GetPostById(c echo.Context) error {
-	postID := c.QueryParam("id")
+func (s *Server) DeletePost(c echo.Context) error {
+	// Create struct for request body
+	type DeleteRequest struct {
+		PostID string `json:"postID"`
+	}
+
+	// Parse request body
+	var req DeleteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
 
-	// Get post from the database
-	query := `SELECT idPost, content_text, created_at, userID FROM posts WHERE idPost = ?`
-	row := db.QueryRow(query, postID)
+	// Validate postID
+	postID, ok := parseID(req.PostID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Post ID is required",
+		})
+	}
 
-	var post Post
-	if err := row.Scan(&post.IDPost, &post.ContentText, &post.CreatedAt, &post.UserID); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to scan post data"})
+	// Only the author or a moderator may delete the post
+	if ok, err := s.authorizePostChange(c, postID); !ok {
+		return err
 	}
 
-	// Return post as JSON response
-	return c.JSON(http.StatusOK, post)
+	// Soft delete; the purge job removes the post for good later
+	err := s.store.DeletePost(c.Request().Context(), postID, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Post not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to delete post: " + err.Error(),
+		})
+	}
+	s.hub.Publish(EventPostDeleted, echo.Map{"idPost": postID}, topicPosts, topicPost(postID))
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Post deleted successfully",
+	})
 }
 
+func (s *Server) GetPostById(c echo.Context) error {
+	postID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid post ID"})
+	}
 
+	// Get post from the store
+	post, err := s.store.GetPost(c.Request().Context(), postID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Post not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
+	}
+	posts := []Post{post}
+	if err := s.withReactions(c, posts); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query reactions"})
+	}
+	if err := s.withAttachments(c.Request().Context(), posts); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
+	}
+
+	// Return post as JSON response
+	return c.JSON(http.StatusOK, posts[0])
+}
 
 func main() {
+	cfg := loadConfig()
+
+	// "migrate" subcommand manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(cfg, os.Args[2:])
+		return
+	}
+	// "timelines" subcommand maintains the fan-out on write timelines
+	if len(os.Args) > 1 && os.Args[1] == "timelines" {
+		runTimelinesCommand(cfg, os.Args[2:])
+		return
+	}
 
-	// Open a connection to the SQLite database
-	database, err := sql.Open("sqlite3", "db.db")
+	// Open the configured store, bringing the schema up to date
+	store, err := openStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer database.Close()
+	defer store.Close()
 
-	db = database
-
-	// Create tables
-	createUsersTable(database)
-	createPostsTable(database)
-	createCommentsTable(database)
+	server := NewServer(store, cfg)
+	go server.runPurgeJob(context.Background())
 
 	// Generate random users, posts, and comments
 	// n := 10 // Number of random entries to generate
 
 	// fmt.Printf("Generating %d random users...\n", n)
-	// insertRandomUsers(n)
+	// server.insertRandomUsers(n)
 
 	// n = n / 2
 	// fmt.Printf("Generating %d random posts...\n", n)
-	// insertRandomPosts(n)
+	// server.insertRandomPosts(n)
 
 	// n = n / 2
 	// fmt.Printf("Generating %d random comments...\n", n)
-	// insertRandomComments(n)
+	// server.insertRandomComments(n)
 
-	// InsertTestUser()
+	// server.InsertTestUser()
 
 	// Start the server
 	e := echo.New()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-        AllowOrigins: []string{"http://localhost:8080", "http://127.0.0.1:8080"},
-        AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE},
-        AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-        AllowCredentials: true,
-    }))
-
-	e.GET("/posts", GetAllPosts)
-	e.GET("/comments", GetAllCommentsToPost)
-	e.GET("/user", GetUserByID)
-	e.GET("/users", GetAllUsers)
-	e.GET("/posts/user", GetPostByUserID)
-	e.GET("/post", GetPostById)
-	e.POST("/addPost", AddPost)
-	e.POST("/addComment", AddComment)
-	e.DELETE("/deletePost", DeletePost)
-	e.PUT("/editPost", EditPost)
-	e.POST("/login", Login)
-	e.PUT("/userEdit", UpdateUser)
-	e.Logger.Fatal(e.Start(":5050"))
+		AllowOrigins:     []string{"http://localhost:8080", "http://127.0.0.1:8080"},
+		AllowMethods:     []string{echo.GET, echo.POST, echo.PUT, echo.DELETE},
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowCredentials: true,
+	}))
 
+	server.Routes(e)
+	e.Logger.Fatal(e.Start(":5050"))
 }
 
-func InsertTestUser() {
-	_, err := db.Exec(`INSERT INTO users (username, displayName, email, password) VALUES (?, ?, ?, ?)`,
-		testingLogin, testingLogin,testingLogin + "@gmail.com", testingPassword)
+func (s *Server) InsertTestUser() {
+	ctx := context.Background()
+
+	hash, err := hashPassword(testingPassword, s.cfg.BcryptCost)
 	if err != nil {
 		log.Fatal(err)
 	}
+	user := User{Username: testingLogin, DisplayName: testingLogin, Email: testingLogin + "@gmail.com", Role: RoleUser}
+	if err := s.store.CreateUser(ctx, &user, hash); err != nil {
+		log.Fatal(err)
+	}
 
 	fmt.Printf("Inserted test user.\n")
 
 	// insert random post and comment for test user
-	_, err = db.Exec(`INSERT INTO posts (content_text, created_at, userID) VALUES (?, ?, ?)`,
-		faker.Sentence(), time.Now().Format(time.RFC3339), 1)
-	if err != nil {
+	post := Post{ContentText: faker.Sentence(), CreatedAt: formatTime(time.Now()), UserID: user.IDUser}
+	if err := s.store.CreatePost(ctx, &post); err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = db.Exec(`INSERT INTO comments (idPost, idUser, content_text, created_at) VALUES (?, ?, ?, ?)`,
-		1, 1, faker.Sentence(), time.Now().Format(time.RFC3339))
-	if err != nil {
+	comment := Comment{IDPost: post.IDPost, IDUser: user.IDUser, ContentText: faker.Sentence(), CreatedAt: formatTime(time.Now())}
+	if err := s.store.CreateComment(ctx, &comment); err != nil {
 		log.Fatal(err)
 	}
 
@@ -500,69 +564,131 @@ func InsertTestUser() {
 }
 
 type UpdateUserRequest struct {
-    ID          int    `json:"id"`
-    Username    string `json:"username"`
-    DisplayName string `json:"displayName"`
-    Email       string `json:"email"`
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
 }
 
-func UpdateUser(c echo.Context) error {
-    var req UpdateUserRequest
-    if err := c.Bind(&req); err != nil {
-        return c.JSON(http.StatusBadRequest, echo.Map{
-            "error": "Invalid request payload",
-        })
-    }
-
-
-    // Update the user in the database
-    stmt, err := db.Prepare("UPDATE users SET username = ?, displayName = ?, email = ? WHERE idUser = ?")
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Database error",
-        })
-    }
-    defer stmt.Close()
-
-    res, err := stmt.Exec(req.Username, req.DisplayName, req.Email, req.ID)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to update user",
-        })
-    }
-
-    rowsAffected, err := res.RowsAffected()
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Database error",
-        })
-    }
-
-    if rowsAffected == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "error": "User not found",
-        })
-    }
-
-    // Retrieve the updated user
-    var updatedUser User
-    err = db.QueryRow("SELECT idUser, username, displayName, email FROM users WHERE idUser = ?", req.ID).
-        Scan(&updatedUser.IDUser, &updatedUser.Username, &updatedUser.DisplayName, &updatedUser.Email)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "error": "Failed to retrieve updated user",
-        })
-    }
-
-    return c.JSON(http.StatusOK, updatedUser)
+// validate applies the rules of RegisterRequest to the fields that are set.
+// Empty fields keep their current value.
+func (r *UpdateUserRequest) validate() map[string]string {
+	fields := map[string]string{}
+	if r.Username != "" {
+		if msg := checkUsername(r.Username); msg != "" {
+			fields["username"] = msg
+		}
+	}
+	if r.DisplayName != "" {
+		if msg := checkDisplayName(r.DisplayName); msg != "" {
+			fields["displayName"] = msg
+		}
+	}
+	if r.Email != "" {
+		if msg := checkEmail(r.Email); msg != "" {
+			fields["email"] = msg
+		}
+	}
+	return fields
+}
+
+func (s *Server) UpdateUser(c echo.Context) error {
+	var req UpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request payload",
+		})
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Email = strings.TrimSpace(req.Email)
+	if fields := req.validate(); len(fields) > 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":  "Invalid user data",
+			"fields": fields,
+		})
+	}
+
+	// Default to the caller's own account; only admins may change others
+	userID := req.ID
+	if userID == 0 {
+		userID = currentUser(c).IDUser
+	}
+	if !canModifyUser(currentUser(c), userID) {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": "You are not allowed to modify this user",
+		})
+	}
+
+	// Fields left empty keep their current value
+	ctx := c.Request().Context()
+	user, err := s.store.GetUser(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to update user",
+		})
+	}
+	if req.Username != "" {
+		user.Username = req.Username
+	}
+	if req.DisplayName != "" {
+		user.DisplayName = req.DisplayName
+	}
+	if req.Email != "" {
+		user.Email = req.Email
+	}
+
+	// Update the user in the store
+	err = s.store.UpdateUser(ctx, user)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error":  "Account already exists",
+			"fields": map[string]string{conflict.Field: "This " + conflict.Field + " is already taken"},
+		})
+	}
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to update user",
+		})
+	}
+
+	// Retrieve the updated user
+	updatedUser, err := s.store.GetUser(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to retrieve updated user",
+		})
+	}
+
+	return c.JSON(http.StatusOK, updatedUser)
 }
 
 type LoginRequest struct {
-    Username string `json:"username"`
-    Password string `json:"password"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is the user JSON extended with the session token that has to
+// be sent as "Authorization: Bearer <token>" on subsequent requests.
+type LoginResponse struct {
+	User
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
 }
 
-func Login(c echo.Context) error {
+func (s *Server) Login(c echo.Context) error {
 	var req LoginRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{
@@ -578,70 +704,45 @@ func Login(c echo.Context) error {
 	}
 
 	// Check if user exists
-	query := `SELECT idUser, username, displayName, email FROM users WHERE (username = ? OR email = ?) AND password = ?`
-	row := db.QueryRow(query, req.Username, req.Username, req.Password)
-
-	var user User
-	if err := row.Scan(&user.IDUser, &user.Username, &user.DisplayName, &user.Email); err != nil {
+	ctx := c.Request().Context()
+	user, stored, err := s.store.GetUserByLogin(ctx, req.Username)
+	if err != nil {
 		return c.JSON(http.StatusUnauthorized, echo.Map{
 			"error": "Invalid username or password",
 		})
 	}
 
-	return c.JSON(http.StatusOK, user)
-}
-
-// Create Users table
-func createUsersTable(db *sql.DB) {
-	createTableSQL := `CREATE TABLE IF NOT EXISTS users (
-		"idUser" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,		
-		"username" TEXT UNIQUE,
-		"displayName" TEXT UNIQUE,
-		"email" TEXT UNIQUE,
-		"password" TEXT
-	);`
-	statement, err := db.Prepare(createTableSQL)
-	if err != nil {
-		log.Fatal(err)
+	ok, needsRehash := verifyPassword(stored, req.Password, s.cfg.BcryptCost)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "Invalid username or password",
+		})
+	}
+	if ok, err := s.refuseSuspended(c, user.IDUser); !ok {
+		return err
 	}
-	statement.Exec()
-	fmt.Println("Users table created")
-}
 
-// Create Posts table
-func createPostsTable(db *sql.DB) {
-	createTableSQL := `CREATE TABLE IF NOT EXISTS posts (
-		"idPost" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,		
-		"content_text" TEXT,
-		"created_at" TEXT,
-		"userID" INTEGER,
-		FOREIGN KEY(userID) REFERENCES users(idUser)
-	);`
-	statement, err := db.Prepare(createTableSQL)
-	if err != nil {
-		log.Fatal(err)
+	// Upgrade plaintext or outdated hashes now that we know the password
+	if needsRehash {
+		if hash, err := hashPassword(req.Password, s.cfg.BcryptCost); err != nil {
+			log.Printf("failed to rehash password for user %d: %v", user.IDUser, err)
+		} else if err := s.store.SetUserPassword(ctx, user.IDUser, hash); err != nil {
+			log.Printf("failed to store rehashed password for user %d: %v", user.IDUser, err)
+		}
 	}
-	statement.Exec()
-	fmt.Println("Posts table created")
-}
 
-// Create Comments table
-func createCommentsTable(db *sql.DB) {
-	createTableSQL := `CREATE TABLE IF NOT EXISTS comments (
-		"idComment" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,		
-		"idPost" INTEGER,
-		"idUser" INTEGER,
-		"content_text" TEXT,
-		"created_at" TEXT,
-		FOREIGN KEY(idPost) REFERENCES posts(idPost),
-		FOREIGN KEY(idUser) REFERENCES users(idUser)
-	);`
-	statement, err := db.Prepare(createTableSQL)
+	token, expiresAt, err := s.createSession(ctx, user.IDUser)
 	if err != nil {
-		log.Fatal(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to create session",
+		})
 	}
-	statement.Exec()
-	fmt.Println("Comments table created")
+
+	return c.JSON(http.StatusOK, LoginResponse{
+		User:      user,
+		Token:     token,
+		ExpiresAt: formatTime(expiresAt),
+	})
 }
 
 // insertRandomUsers generates and inserts synthetic user data into the database for testing purposes.
@@ -652,25 +753,30 @@ func createCommentsTable(db *sql.DB) {
 //
 // The function will:
 //   - Generate unique random usernames, display names and emails for each user
+//   - Store a bcrypt hash of a random password for each user
 //   - Insert the generated data into the 'users' table
 //   - Print confirmation message after successful insertion
 //
 // Example usage:
 //
-//	insertRandomUsers(100) // Generates 100 random users
+//	server.insertRandomUsers(100) // Generates 100 random users
 //
-// Note: This function will fail fast with log.Fatal if any database errors occur
-func insertRandomUsers(n int) {
+// Note: This function will fail fast with log.Fatal if any store errors occur
+func (s *Server) insertRandomUsers(n int) {
 	for i := 0; i < n; i++ {
-		username := faker.Username()
-		displayName := faker.Username()
-		email := faker.Email()
-		password := faker.Password()
-		_, err := db.Exec(`INSERT INTO users (username, displayName, email, password) VALUES (?, ?, ?, ?)`,
-			username, displayName, email, password)
+		user := User{
+			Username:    faker.Username(),
+			DisplayName: faker.Username(),
+			Email:       faker.Email(),
+			Role:        RoleUser,
+		}
+		password, err := hashPassword(faker.Password(), s.cfg.BcryptCost)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if err := s.store.CreateUser(context.Background(), &user, password); err != nil {
+			log.Fatal(err)
+		}
 	}
 	fmt.Printf("Inserted %d random users.\n", n)
 }
@@ -682,7 +788,7 @@ func insertRandomUsers(n int) {
 //   - n: The maximum number of posts to generate per user
 //
 // The function will:
-//   - Retrieve all existing users from the database
+//   - Retrieve all existing users from the store
 //   - For each user, generate between 1 and n random posts
 //   - Set the creation timestamp to the current time
 //   - Insert the generated posts into the 'posts' table
@@ -690,22 +796,29 @@ func insertRandomUsers(n int) {
 //
 // Example usage:
 //
-//	insertRandomPosts(5) // Generates up to 5 posts per user
+//	server.insertRandomPosts(5) // Generates up to 5 posts per user
 //
 // Note:
 //   - Requires existing users in the database
-//   - Will fail fast with log.Fatal if any database errors occur
-func insertRandomPosts(n int) {
-	Users := GetUsers()
+//   - Will fail fast with log.Fatal if any store errors occur
+func (s *Server) insertRandomPosts(n int) {
+	ctx := context.Background()
+	Users, err := collectAll(func(page PageRequest) ([]User, error) {
+		return s.store.ListUsers(ctx, page)
+	}, userCursor)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	for _, user := range Users {
 		numberOfPosts := rand.Intn(n) + 1
 		for i := 0; i < numberOfPosts; i++ {
-			content := faker.Sentence()
-			createdAt := time.Now().Format(time.RFC3339)
-			_, err := db.Exec(`INSERT INTO posts (content_text, created_at, userID) VALUES (?, ?, ?)`,
-				content, createdAt, user.IDUser)
-			if err != nil {
+			post := Post{
+				ContentText: faker.Sentence(),
+				CreatedAt:   formatTime(time.Now()),
+				UserID:      user.IDUser,
+			}
+			if err := s.store.CreatePost(ctx, &post); err != nil {
 				log.Fatal(err)
 			}
 		}
@@ -721,7 +834,7 @@ func insertRandomPosts(n int) {
 //   - n: The maximum number of comments to generate per post
 //
 // The function will:
-//   - Retrieve all existing posts and users from the database
+//   - Retrieve all existing posts and users from the store
 //   - For each post, generate between 1 and n random comments
 //   - Randomly assign each comment to an existing user
 //   - Set the creation timestamp to the current time
@@ -730,25 +843,38 @@ func insertRandomPosts(n int) {
 //
 // Example usage:
 //
-//	insertRandomComments(10) // Generates up to 10 comments per post
+//	server.insertRandomComments(10) // Generates up to 10 comments per post
 //
 // Note:
 //   - Requires existing posts and users in the database
-//   - Will fail fast with log.Fatal if any database errors occur
+//   - Will fail fast with log.Fatal if any store errors occur
 //   - Uses random user selection, so comment distribution may not be uniform
-func insertRandomComments(n int) {
-	Posts := GetPosts()
-	Users := GetUsers()
+func (s *Server) insertRandomComments(n int) {
+	ctx := context.Background()
+	Posts, err := collectAll(func(page PageRequest) ([]Post, error) {
+		return s.store.ListPosts(ctx, 0, page)
+	}, postCursor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Users, err := collectAll(func(page PageRequest) ([]User, error) {
+		return s.store.ListUsers(ctx, page)
+	}, userCursor)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	for _, post := range Posts {
 		numberOfComments := rand.Intn(n) + 1
 
 		for i := 0; i < numberOfComments; i++ {
-			content := faker.Sentence()
-			createdAt := time.Now().Format(time.RFC3339)
-			_, err := db.Exec(`INSERT INTO comments (idPost, idUser, content_text, created_at) VALUES (?, ?, ?, ?)`,
-				post.IDPost, Users[rand.Intn(len(Users))].IDUser, content, createdAt)
-			if err != nil {
+			comment := Comment{
+				IDPost:      post.IDPost,
+				IDUser:      Users[rand.Intn(len(Users))].IDUser,
+				ContentText: faker.Sentence(),
+				CreatedAt:   formatTime(time.Now()),
+			}
+			if err := s.store.CreateComment(ctx, &comment); err != nil {
 				log.Fatal(err)
 			}
 		}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// maxImagePixels bounds the decoded size of uploads, which keeps small
+	// files with huge dimensions from exhausting memory.
+	maxImagePixels = 40_000_000
+	// thumbnailSize is the bounding box of generated thumbnails.
+	thumbnailSize = 320
+	jpegQuality   = 90
+)
+
+var (
+	errUnsupportedMedia = errors.New("unsupported media type")
+	errImageTooLarge    = errors.New("image dimensions too large")
+	errInvalidImage     = errors.New("invalid image")
+)
+
+// processedImage is an upload ready to be stored: re-encoded without
+// metadata, plus a thumbnail.
+type processedImage struct {
+	data        []byte
+	contentType string
+	ext         string
+	width       int
+	height      int
+
+	thumbnail            []byte
+	thumbnailContentType string
+	thumbnailExt         string
+}
+
+// processImage checks that data is an image of a supported type and
+// re-encodes it. Decoding and encoding again drops every metadata block,
+// EXIF included; the EXIF orientation of JPEGs is applied to the pixels
+// first so the image does not turn sideways. WebP has no encoder in the
+// standard library and is stored as PNG.
+func processImage(data []byte) (processedImage, error) {
+	var out processedImage
+
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return out, errUnsupportedMedia
+	}
+
+	var config image.Config
+	var err error
+	if contentType == "image/webp" {
+		config, err = webp.DecodeConfig(bytes.NewReader(data))
+	} else {
+		config, _, err = image.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil || config.Width < 1 || config.Height < 1 {
+		return out, errInvalidImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return out, errImageTooLarge
+	}
+	// DecodeAll allocates a canvas-sized frame for every frame, so the frames
+	// of an animation share the budget
+	if contentType == "image/gif" && gifFrameCount(data)*config.Width*config.Height > maxImagePixels {
+		return out, errImageTooLarge
+	}
+
+	var img image.Image
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = orient(img, exifOrientation(data))
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		}
+		out.contentType, out.ext = "image/jpeg", ".jpg"
+	case "image/png", "image/webp":
+		if contentType == "image/png" {
+			img, err = png.Decode(bytes.NewReader(data))
+		} else {
+			img, err = webp.Decode(bytes.NewReader(data))
+		}
+		if err == nil {
+			err = png.Encode(&buf, img)
+		}
+		out.contentType, out.ext = "image/png", ".png"
+	case "image/gif":
+		// Keep every frame of animations
+		var g *gif.GIF
+		g, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil {
+			img = firstFrame(g)
+			err = gif.EncodeAll(&buf, g)
+		}
+		out.contentType, out.ext = "image/gif", ".gif"
+	}
+	if err != nil {
+		return out, errInvalidImage
+	}
+	out.data = buf.Bytes()
+	out.width, out.height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	thumb := thumbnail(img, thumbnailSize)
+	buf = bytes.Buffer{}
+	if thumb.Opaque() {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		out.thumbnailContentType, out.thumbnailExt = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(&buf, thumb)
+		out.thumbnailContentType, out.thumbnailExt = "image/png", ".png"
+	}
+	if err != nil {
+		return out, err
+	}
+	out.thumbnail = buf.Bytes()
+	return out, nil
+}
+
+// firstFrame renders the first frame of an animation onto its canvas.
+func firstFrame(g *gif.GIF) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	if len(g.Image) > 0 {
+		draw.Draw(canvas, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+	}
+	return canvas
+}
+
+// gifFrameCount counts the frames of a GIF by walking its blocks, without
+// decoding any. It stops at the first malformed block and leaves reporting it
+// to the decoder.
+func gifFrameCount(data []byte) int {
+	// Header and logical screen descriptor, then the global color table
+	const header = 13
+	if len(data) < header {
+		return 0
+	}
+	i := header
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks returns the index after the sub-blocks starting at i,
+	// or -1 if they run past the end.
+	skipSubBlocks := func(i int) int {
+		for i < len(data) {
+			size := int(data[i])
+			i++
+			if size == 0 {
+				return i
+			}
+			i += size
+		}
+		return -1
+	}
+
+	frames := 0
+	for i >= 0 && i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, then sub-blocks
+			i = skipSubBlocks(i + 2)
+		case 0x2C: // image descriptor, local color table, LZW code size, data
+			if i+10 > len(data) {
+				return frames
+			}
+			frames++
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i = skipSubBlocks(i + 1)
+		default: // trailer or garbage
+			return frames
+		}
+	}
+	return frames
+}
+
+// thumbnail scales img down to fit a size×size box. Smaller images are
+// copied as they are.
+func thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// exifOrientation reads the orientation tag (1 to 8) from the EXIF block of
+// a JPEG. It returns 1, the upright orientation, if there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		// Image data follows start of scan; metadata comes before it
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in the first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + 12*n
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient transforms img so that it appears upright for the given EXIF
+// orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // needs a 90° clockwise turn
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs a 90° counterclockwise turn
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// encodeGIF encodes an animation of size×size frames, each with its own
+// palette so that some have local color tables.
+func encodeGIF(t *testing.T, frames, size int) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	for i := range frames {
+		palette := color.Palette{color.Black, color.RGBA{uint8(i), 0, 0, 255}}
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, size, size), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	for _, frames := range []int{1, 2, 7} {
+		if got := gifFrameCount(encodeGIF(t, frames, 4)); got != frames {
+			t.Errorf("gifFrameCount of %d frames = %d", frames, got)
+		}
+	}
+	for _, data := range [][]byte{nil, []byte("GIF89a"), encodeGIF(t, 3, 4)[:30]} {
+		if got := gifFrameCount(data); got > 1 {
+			t.Errorf("gifFrameCount of %d truncated bytes = %d", len(data), got)
+		}
+	}
+}
+
+func TestProcessImageGIFBudget(t *testing.T) {
+	if _, err := processImage(encodeGIF(t, 3, 64)); err != nil {
+		t.Errorf("small animation: %v", err)
+	}
+	// Every frame fits the budget, all of them together do not
+	const size = 1000
+	frames := maxImagePixels/(size*size) + 1
+	if _, err := processImage(encodeGIF(t, frames, size)); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("%d frames of %d×%d: err = %v, want %v", frames, size, size, err, errImageTooLarge)
+	}
+}
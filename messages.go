@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxConversationMembers caps the size of group conversations, the caller
+// included.
+const maxConversationMembers = 10
+
+// maxConversationTitleLength caps group titles, in characters.
+const maxConversationTitleLength = 100
+
+// Conversation is a private exchange of messages between its members.
+type Conversation struct {
+	IDConversation int    `json:"idConversation"`
+	Title          string `json:"title,omitempty"`
+	// Direct conversations are between two users; there is at most one per
+	// pair, and starting another one returns it.
+	Direct    bool   `json:"direct"`
+	CreatedAt string `json:"created_at"`
+	// UpdatedAt is when the last message was sent, or CreatedAt before.
+	UpdatedAt   string               `json:"updated_at"`
+	Members     []ConversationMember `json:"members"`
+	LastMessage *Message             `json:"lastMessage"`
+	// UnreadCount is the number of messages from others the viewer has not
+	// read yet.
+	UnreadCount int `json:"unreadCount"`
+}
+
+func conversationCursor(c Conversation) Cursor {
+	return Cursor{CreatedAt: c.UpdatedAt, ID: c.IDConversation}
+}
+
+// ConversationMember is a member of a conversation with their read receipt:
+// the last message they read and when.
+type ConversationMember struct {
+	UserID            int     `json:"userID"`
+	Username          string  `json:"username"`
+	DisplayName       string  `json:"displayName"`
+	JoinedAt          string  `json:"joined_at"`
+	LastReadMessageID int     `json:"lastReadMessageID"`
+	ReadAt            *string `json:"read_at"`
+}
+
+type Message struct {
+	IDMessage      int    `json:"idMessage"`
+	ConversationID int    `json:"idConversation"`
+	SenderID       int    `json:"senderID"`
+	ContentText    string `json:"content_text"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func messageCursor(m Message) Cursor { return Cursor{CreatedAt: m.CreatedAt, ID: m.IDMessage} }
+
+// ReadReceipt is the stream event sent to the members of a conversation
+// when one of them reads it.
+type ReadReceipt struct {
+	ConversationID    int    `json:"idConversation"`
+	UserID            int    `json:"userID"`
+	LastReadMessageID int    `json:"lastReadMessageID"`
+	ReadAt            string `json:"read_at"`
+}
+
+// directKey identifies the direct conversation between two users.
+func directKey(a, b int) string {
+	return strconv.Itoa(min(a, b)) + ":" + strconv.Itoa(max(a, b))
+}
+
+// memberTopics are the stream topics of the members of c.
+func memberTopics(c Conversation) []string {
+	topics := make([]string, len(c.Members))
+	for i, m := range c.Members {
+		topics[i] = topicUser(m.UserID)
+	}
+	return topics
+}
+
+// loadConversation looks up a conversation of the caller and writes the 404
+// response if there is none. Conversations of others are reported as
+// missing, so their existence does not leak.
+func (s *Server) loadConversation(c echo.Context, conversationID int) (Conversation, bool, error) {
+	conversation, err := s.store.GetConversation(c.Request().Context(), conversationID, currentUser(c).IDUser)
+	if errors.Is(err, ErrNotFound) {
+		return conversation, false, c.JSON(http.StatusNotFound, echo.Map{"error": "Conversation not found"})
+	}
+	if err != nil {
+		return conversation, false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query conversation"})
+	}
+	return conversation, true, nil
+}
+
+// AddConversation handles POST /addConversation {userIDs, title}. A single
+// other user starts a direct conversation, or returns the existing one;
+// more start a group.
+func (s *Server) AddConversation(c echo.Context) error {
+	type ConversationRequest struct {
+		UserIDs []string `json:"userIDs"`
+		Title   string   `json:"title"`
+	}
+
+	var req ConversationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	callerID := currentUser(c).IDUser
+	memberIDs := []int{callerID}
+	for _, value := range req.UserIDs {
+		id, ok := parseID(value)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid user ID " + strconv.Quote(value)})
+		}
+		if !slices.Contains(memberIDs, id) {
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	if len(memberIDs) < 2 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "At least one other user is required"})
+	}
+	if len(memberIDs) > maxConversationMembers {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "A conversation can have at most " + strconv.Itoa(maxConversationMembers) + " members"})
+	}
+	title := strings.TrimSpace(req.Title)
+	if len([]rune(title)) > maxConversationTitleLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Title must not be longer than " + strconv.Itoa(maxConversationTitleLength) + " characters"})
+	}
+	direct := len(memberIDs) == 2
+	if direct && title != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Only group conversations have a title"})
+	}
+	if ok, err := s.refuseBlocked(c, callerID, "You cannot message these users", memberIDs[1:]...); !ok {
+		return err
+	}
+
+	now := formatTime(time.Now())
+	conversation := Conversation{Title: title, Direct: direct, CreatedAt: now, UpdatedAt: now}
+	ctx := c.Request().Context()
+	err := s.store.CreateConversation(ctx, &conversation, memberIDs)
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create conversation: " + err.Error()})
+	}
+
+	created, err := s.store.GetConversation(ctx, conversation.IDConversation, callerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query conversation"})
+	}
+	return c.JSON(http.StatusOK, created)
+}
+
+// GetConversations handles GET /conversations: the caller's conversations
+// with their last message, most recently active first.
+func (s *Server) GetConversations(c echo.Context) error {
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	conversations, err := s.store.ListConversations(c.Request().Context(), currentUser(c).IDUser, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query conversations"})
+	}
+	return c.JSON(http.StatusOK, newPage(conversations, page, conversationCursor))
+}
+
+// GetConversation handles GET /conversation?id=, which includes the read
+// receipts of the members.
+func (s *Server) GetConversation(c echo.Context) error {
+	conversationID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid conversation ID"})
+	}
+	conversation, ok, err := s.loadConversation(c, conversationID)
+	if !ok {
+		return err
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// GetMessages handles GET /messages?conversationID=: the history of a
+// conversation, newest first.
+func (s *Server) GetMessages(c echo.Context) error {
+	conversationID, ok := parseID(c.QueryParam("conversationID"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid conversation ID"})
+	}
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+	if _, ok, err := s.loadConversation(c, conversationID); !ok {
+		return err
+	}
+
+	messages, err := s.store.ListMessages(c.Request().Context(), conversationID, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query messages"})
+	}
+	return c.JSON(http.StatusOK, newPage(messages, page, messageCursor))
+}
+
+func (s *Server) SendMessage(c echo.Context) error {
+	type MessageRequest struct {
+		ConversationID string `json:"conversationID"`
+		ContentText    string `json:"contentText"`
+	}
+
+	var req MessageRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	conversationID, ok := parseID(req.ConversationID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Conversation ID is required"})
+	}
+	if strings.TrimSpace(req.ContentText) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Content text is required"})
+	}
+
+	conversation, ok, err := s.loadConversation(c, conversationID)
+	if !ok {
+		return err
+	}
+	senderID := currentUser(c).IDUser
+	// A block also closes the groups the blocker shares with the sender,
+	// which would otherwise still deliver to the blocker
+	refusal := "You cannot message this user"
+	if !conversation.Direct {
+		refusal = "A member of this conversation has blocked you"
+	}
+	for _, m := range conversation.Members {
+		if m.UserID == senderID {
+			continue
+		}
+		if ok, err := s.refuseBlocked(c, senderID, refusal, m.UserID); !ok {
+			return err
+		}
+	}
+	message := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		ContentText:    req.ContentText,
+		CreatedAt:      formatTime(time.Now()),
+	}
+	err = s.store.CreateMessage(c.Request().Context(), &message)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Conversation not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to send message: " + err.Error()})
+	}
+	s.hub.Publish(EventMessageCreated, message, memberTopics(conversation)...)
+
+	return c.JSON(http.StatusCreated, message)
+}
+
+// ReadConversation handles PUT /readConversation {conversationID,
+// messageID}: the caller has read the conversation up to the message, or
+// to the end without one. Read receipts never move backwards.
+func (s *Server) ReadConversation(c echo.Context) error {
+	type ReadRequest struct {
+		ConversationID string `json:"conversationID"`
+		MessageID      string `json:"messageID"`
+	}
+
+	var req ReadRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	conversationID, ok := parseID(req.ConversationID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Conversation ID is required"})
+	}
+	messageID := 0
+	if req.MessageID != "" {
+		if messageID, ok = parseID(req.MessageID); !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid message ID"})
+		}
+	}
+
+	conversation, ok, err := s.loadConversation(c, conversationID)
+	if !ok {
+		return err
+	}
+	receipt, err := s.store.MarkConversationRead(c.Request().Context(), conversationID, currentUser(c).IDUser, messageID, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Message not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update read receipt: " + err.Error()})
+	}
+	s.hub.Publish(EventConversationRead, receipt, memberTopics(conversation)...)
+
+	return c.JSON(http.StatusOK, receipt)
+}
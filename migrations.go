@@ -0,0 +1,816 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// migration is one numbered, reversible schema change. Versions must be
+// unique and increasing; a migration is never edited once it has shipped,
+// add a new one instead.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+	// disableForeignKeys runs the step with SQLite foreign key enforcement
+	// off, as required for rebuilding tables that others reference. Foreign
+	// keys are checked before the step commits.
+	disableForeignKeys bool
+	// adoptIf is a query that returns a row when the schema already has the
+	// change, as databases created before migrations existed may. The step
+	// is then recorded as applied without running up.
+	adoptIf string
+	// searchIndex marks the step that creates the SQLite search index. It is
+	// recorded without running when SQLite lacks FTS5, and reverted only if
+	// the index exists.
+	searchIndex bool
+	// searchTriggers recreates the triggers of the search index after the
+	// step, for steps that rebuild the indexed tables.
+	searchTriggers bool
+}
+
+// sqliteMigrations is the ordered schema history of SQLite databases. Version
+// 1 is the schema that used to be created by createUsersTable,
+// createPostsTable and createCommentsTable. Versions 1 to 3 use IF NOT EXISTS
+// or adoptIf so that databases created before migrations existed, which may
+// already have sessions and roles, are adopted as-is. Every migration must
+// have a counterpart with the same version in postgresMigrations.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: `CREATE TABLE IF NOT EXISTS users (
+			"idUser" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"username" TEXT UNIQUE,
+			"displayName" TEXT UNIQUE,
+			"email" TEXT UNIQUE,
+			"password" TEXT
+		);
+		CREATE TABLE IF NOT EXISTS posts (
+			"idPost" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"content_text" TEXT,
+			"created_at" TEXT,
+			"userID" INTEGER,
+			FOREIGN KEY(userID) REFERENCES users(idUser)
+		);
+		CREATE TABLE IF NOT EXISTS comments (
+			"idComment" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"idPost" INTEGER,
+			"idUser" INTEGER,
+			"content_text" TEXT,
+			"created_at" TEXT,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost),
+			FOREIGN KEY(idUser) REFERENCES users(idUser)
+		);`,
+		down: `DROP TABLE comments;
+		DROP TABLE posts;
+		DROP TABLE users;`,
+	},
+	{
+		version: 2,
+		name:    "create_sessions",
+		up: `CREATE TABLE IF NOT EXISTS sessions (
+			"token_hash" TEXT NOT NULL PRIMARY KEY,
+			"idUser" INTEGER NOT NULL,
+			"created_at" TEXT,
+			"expires_at" TEXT,
+			FOREIGN KEY(idUser) REFERENCES users(idUser)
+		);`,
+		down: `DROP TABLE sessions;`,
+	},
+	{
+		version: 3,
+		name:    "add_users_role",
+		up:      `ALTER TABLE users ADD COLUMN "role" TEXT NOT NULL DEFAULT 'user';`,
+		down:    `ALTER TABLE users DROP COLUMN "role";`,
+		adoptIf: `SELECT 1 FROM pragma_table_info('users') WHERE name = 'role'`,
+	},
+	{
+		// Keyset pagination compares created_at as text, which only orders
+		// correctly when every row uses the same offset. SQLite's date
+		// functions understand the "+01:00" suffix and convert to UTC.
+		version: 4,
+		name:    "paginate_by_created_at",
+		up: `UPDATE posts SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+			WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created_at) IS NOT NULL;
+		UPDATE comments SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+			WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created_at) IS NOT NULL;
+		CREATE INDEX posts_created_at ON posts(created_at, idPost);
+		CREATE INDEX posts_user_created_at ON posts(userID, created_at, idPost);
+		CREATE INDEX comments_post_created_at ON comments(idPost, created_at, idComment);`,
+		down: `DROP INDEX comments_post_created_at;
+		DROP INDEX posts_user_created_at;
+		DROP INDEX posts_created_at;`,
+	},
+	{
+		// External-content FTS5 indexes kept in sync by triggers. FTS5 is only
+		// compiled into go-sqlite3 with the sqlite_fts5 build tag; without it
+		// the step is recorded as applied without running, and search matches
+		// substrings until a build with FTS5 opens the database and creates
+		// the index (see initSearchIndex).
+		version:     5,
+		name:        "create_search_index",
+		searchIndex: true,
+		up: `CREATE VIRTUAL TABLE posts_fts USING fts5(content_text, content='posts', content_rowid='idPost');
+		CREATE VIRTUAL TABLE comments_fts USING fts5(content_text, content='comments', content_rowid='idComment');
+		CREATE VIRTUAL TABLE users_fts USING fts5(username, displayName, content='users', content_rowid='idUser');
+		INSERT INTO posts_fts(posts_fts) VALUES ('rebuild');
+		INSERT INTO comments_fts(comments_fts) VALUES ('rebuild');
+		INSERT INTO users_fts(users_fts) VALUES ('rebuild');
+		` + sqliteSearchTriggers,
+		down: `DROP TRIGGER users_fts_update;
+		DROP TRIGGER users_fts_delete;
+		DROP TRIGGER users_fts_insert;
+		DROP TRIGGER comments_fts_update;
+		DROP TRIGGER comments_fts_delete;
+		DROP TRIGGER comments_fts_insert;
+		DROP TRIGGER posts_fts_update;
+		DROP TRIGGER posts_fts_delete;
+		DROP TRIGGER posts_fts_insert;
+		DROP TABLE users_fts;
+		DROP TABLE comments_fts;
+		DROP TABLE posts_fts;`,
+	},
+	{
+		// No REFERENCES clause: SQLite cannot drop a column that is part of
+		// a foreign key, and foreign keys are not enforced here anyway.
+		version: 6,
+		name:    "add_comments_parent",
+		up: `ALTER TABLE comments ADD COLUMN "parentCommentID" INTEGER;
+		CREATE INDEX comments_parent ON comments(parentCommentID);`,
+		down: `DROP INDEX comments_parent;
+		ALTER TABLE comments DROP COLUMN "parentCommentID";`,
+	},
+	{
+		version: 7,
+		name:    "add_comments_edited_deleted",
+		up: `ALTER TABLE comments ADD COLUMN "edited_at" TEXT;
+		ALTER TABLE comments ADD COLUMN "deleted_at" TEXT;`,
+		down: `ALTER TABLE comments DROP COLUMN "deleted_at";
+		ALTER TABLE comments DROP COLUMN "edited_at";`,
+	},
+	{
+		// Foreign keys are enforced from now on (see openSQLiteStore), so
+		// rows that point nowhere are removed first. Deleted posts used to
+		// leave their comments behind.
+		version: 8,
+		name:    "enforce_foreign_keys",
+		up: `DELETE FROM posts WHERE userID NOT IN (SELECT idUser FROM users);
+		DELETE FROM comments WHERE idPost NOT IN (SELECT idPost FROM posts)
+			OR idUser NOT IN (SELECT idUser FROM users);
+		UPDATE comments SET parentCommentID = NULL
+			WHERE parentCommentID NOT IN (SELECT idComment FROM comments);` + rebuildContentTables(true),
+		down:               rebuildContentTables(false),
+		disableForeignKeys: true,
+		searchTriggers:     true,
+	},
+	{
+		version: 9,
+		name:    "add_posts_deleted_at",
+		up: `ALTER TABLE posts ADD COLUMN "deleted_at" TEXT;
+		CREATE INDEX posts_deleted_at ON posts(deleted_at) WHERE deleted_at IS NOT NULL;`,
+		down: `DROP INDEX posts_deleted_at;
+		ALTER TABLE posts DROP COLUMN "deleted_at";`,
+	},
+	{
+		// The current text of every post becomes its first revision.
+		version: 10,
+		name:    "create_post_revisions",
+		up: `CREATE TABLE post_revisions (
+			"idPost" INTEGER NOT NULL,
+			"revision" INTEGER NOT NULL,
+			"editorID" INTEGER NOT NULL,
+			"content_text" TEXT,
+			"created_at" TEXT,
+			PRIMARY KEY (idPost, revision),
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			FOREIGN KEY(editorID) REFERENCES users(idUser)
+		);
+		INSERT INTO post_revisions (idPost, revision, editorID, content_text, created_at)
+			SELECT idPost, 1, userID, content_text, created_at FROM posts WHERE userID IS NOT NULL;`,
+		down: `DROP TABLE post_revisions;`,
+	},
+	{
+		// timeline_entries is only filled with TIMELINE_FANOUT=write.
+		version: 11,
+		name:    "create_follows_timelines",
+		up: `CREATE TABLE follows (
+			"followerID" INTEGER NOT NULL,
+			"followeeID" INTEGER NOT NULL,
+			"created_at" TEXT,
+			PRIMARY KEY (followerID, followeeID),
+			FOREIGN KEY(followerID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(followeeID) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX follows_followee ON follows(followeeID, created_at);
+		CREATE INDEX follows_follower_created_at ON follows(followerID, created_at);
+		CREATE TABLE timeline_entries (
+			"userID" INTEGER NOT NULL,
+			"idPost" INTEGER NOT NULL,
+			"created_at" TEXT,
+			PRIMARY KEY (userID, idPost),
+			FOREIGN KEY(userID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE
+		);
+		CREATE INDEX timeline_entries_user_created_at ON timeline_entries(userID, created_at, idPost);
+		CREATE INDEX timeline_entries_post ON timeline_entries(idPost);`,
+		down: `DROP TABLE timeline_entries;
+		DROP TABLE follows;`,
+	},
+	{
+		// A reaction belongs to either a post or a comment. NULLs are
+		// distinct in UNIQUE constraints, so each constraint only applies to
+		// its own kind of target.
+		version: 12,
+		name:    "create_reactions",
+		up: `CREATE TABLE reactions (
+			"idReaction" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"idPost" INTEGER,
+			"idComment" INTEGER,
+			"idUser" INTEGER NOT NULL,
+			"reaction" TEXT NOT NULL,
+			"created_at" TEXT,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			FOREIGN KEY(idComment) REFERENCES comments(idComment) ON DELETE CASCADE,
+			FOREIGN KEY(idUser) REFERENCES users(idUser) ON DELETE CASCADE,
+			CHECK ((idPost IS NULL) <> (idComment IS NULL)),
+			UNIQUE (idPost, idUser, reaction),
+			UNIQUE (idComment, idUser, reaction)
+		);
+		CREATE INDEX reactions_post_created_at ON reactions(idPost, created_at, idReaction);
+		CREATE INDEX reactions_comment_created_at ON reactions(idComment, created_at, idReaction);`,
+		down: `DROP TABLE reactions;`,
+	},
+	{
+		// A notification stands for events of one kind about one target;
+		// notification_actors holds who caused them, once per user.
+		version: 13,
+		name:    "create_notifications",
+		up: `CREATE TABLE notifications (
+			"idNotification" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"userID" INTEGER NOT NULL,
+			"kind" TEXT NOT NULL,
+			"idPost" INTEGER,
+			"idComment" INTEGER,
+			"actorID" INTEGER NOT NULL,
+			"actorCount" INTEGER NOT NULL,
+			"updated_at" TEXT,
+			"read_at" TEXT,
+			FOREIGN KEY(userID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			FOREIGN KEY(idComment) REFERENCES comments(idComment) ON DELETE CASCADE,
+			FOREIGN KEY(actorID) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX notifications_user_updated_at ON notifications(userID, updated_at, idNotification);
+		CREATE INDEX notifications_unread ON notifications(userID, kind) WHERE read_at IS NULL;
+		CREATE INDEX notifications_post ON notifications(idPost);
+		CREATE INDEX notifications_comment ON notifications(idComment);
+		CREATE TABLE notification_actors (
+			"idNotification" INTEGER NOT NULL,
+			"actorID" INTEGER NOT NULL,
+			PRIMARY KEY (idNotification, actorID),
+			FOREIGN KEY(idNotification) REFERENCES notifications(idNotification) ON DELETE CASCADE,
+			FOREIGN KEY(actorID) REFERENCES users(idUser) ON DELETE CASCADE
+		);`,
+		down: `DROP TABLE notification_actors;
+		DROP TABLE notifications;`,
+	},
+	{
+		// Rows of a post have a NULL idComment, rows of a comment carry the
+		// post as well, so that purging a post finds them.
+		version: 14,
+		name:    "create_mentions_hashtags",
+		up: `CREATE TABLE mentions (
+			"userID" INTEGER NOT NULL,
+			"idPost" INTEGER NOT NULL,
+			"idComment" INTEGER,
+			"created_at" TEXT,
+			FOREIGN KEY(userID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			FOREIGN KEY(idComment) REFERENCES comments(idComment) ON DELETE CASCADE
+		);
+		CREATE INDEX mentions_post ON mentions(idPost, idComment);
+		CREATE INDEX mentions_comment ON mentions(idComment);
+		CREATE INDEX mentions_user ON mentions(userID, created_at);
+		CREATE TABLE hashtags (
+			"tag" TEXT NOT NULL,
+			"idPost" INTEGER NOT NULL,
+			"idComment" INTEGER,
+			"created_at" TEXT,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			FOREIGN KEY(idComment) REFERENCES comments(idComment) ON DELETE CASCADE
+		);
+		CREATE INDEX hashtags_tag ON hashtags(tag, idPost);
+		CREATE INDEX hashtags_created_at ON hashtags(created_at);
+		CREATE INDEX hashtags_post ON hashtags(idPost, idComment);
+		CREATE INDEX hashtags_comment ON hashtags(idComment);`,
+		down: `DROP TABLE hashtags;
+		DROP TABLE mentions;`,
+	},
+	{
+		version: 15,
+		name:    "create_attachments",
+		up: `CREATE TABLE attachments (
+			"idAttachment" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"idPost" INTEGER NOT NULL,
+			"blob_key" TEXT NOT NULL,
+			"thumbnail_key" TEXT NOT NULL,
+			"content_type" TEXT NOT NULL,
+			"width" INTEGER NOT NULL,
+			"height" INTEGER NOT NULL,
+			"size" INTEGER NOT NULL,
+			"alt_text" TEXT NOT NULL DEFAULT '',
+			"created_at" TEXT,
+			FOREIGN KEY(idPost) REFERENCES posts(idPost) ON DELETE CASCADE
+		);
+		CREATE INDEX attachments_post ON attachments(idPost, idAttachment);`,
+		down: `DROP TABLE attachments;`,
+	},
+	{
+		// direct_key is "<lower user ID>:<higher user ID>" for direct
+		// conversations and NULL for groups, so each pair has one.
+		version: 16,
+		name:    "create_conversations_messages",
+		up: `CREATE TABLE conversations (
+			"idConversation" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"title" TEXT NOT NULL DEFAULT '',
+			"direct_key" TEXT UNIQUE,
+			"created_at" TEXT,
+			"updated_at" TEXT
+		);
+		CREATE TABLE conversation_members (
+			"idConversation" INTEGER NOT NULL,
+			"userID" INTEGER NOT NULL,
+			"joined_at" TEXT,
+			"last_read_message_id" INTEGER NOT NULL DEFAULT 0,
+			"read_at" TEXT,
+			PRIMARY KEY (idConversation, userID),
+			FOREIGN KEY(idConversation) REFERENCES conversations(idConversation) ON DELETE CASCADE,
+			FOREIGN KEY(userID) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX conversation_members_user ON conversation_members(userID);
+		CREATE TABLE messages (
+			"idMessage" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"idConversation" INTEGER NOT NULL,
+			"senderID" INTEGER NOT NULL,
+			"content_text" TEXT NOT NULL,
+			"created_at" TEXT,
+			FOREIGN KEY(idConversation) REFERENCES conversations(idConversation) ON DELETE CASCADE,
+			FOREIGN KEY(senderID) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX messages_conversation_created_at ON messages(idConversation, created_at, idMessage);`,
+		down: `DROP TABLE messages;
+		DROP TABLE conversation_members;
+		DROP TABLE conversations;`,
+	},
+	{
+		version: 17,
+		name:    "create_restrictions",
+		up: `CREATE TABLE restrictions (
+			"userID" INTEGER NOT NULL,
+			"targetID" INTEGER NOT NULL,
+			"kind" TEXT NOT NULL,
+			"created_at" TEXT,
+			PRIMARY KEY (userID, targetID, kind),
+			FOREIGN KEY(userID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(targetID) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX restrictions_user_kind_created_at ON restrictions(userID, kind, created_at);`,
+		down: `DROP TABLE restrictions;`,
+	},
+	{
+		// Reports keep a copy of the reported text and no foreign key to
+		// it, so they outlive the content a moderator deletes.
+		version: 18,
+		name:    "create_reports_moderation",
+		up: `CREATE TABLE reports (
+			"idReport" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"reporterID" INTEGER NOT NULL,
+			"idPost" INTEGER NOT NULL,
+			"idComment" INTEGER,
+			"authorID" INTEGER NOT NULL,
+			"content_text" TEXT NOT NULL,
+			"reason" TEXT NOT NULL,
+			"details" TEXT NOT NULL DEFAULT '',
+			"status" TEXT NOT NULL DEFAULT 'open',
+			"created_at" TEXT,
+			"claimedBy" INTEGER,
+			"claimed_at" TEXT,
+			"resolvedBy" INTEGER,
+			"resolved_at" TEXT,
+			"action" TEXT NOT NULL DEFAULT '',
+			"note" TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(reporterID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(authorID) REFERENCES users(idUser) ON DELETE CASCADE,
+			FOREIGN KEY(claimedBy) REFERENCES users(idUser) ON DELETE SET NULL,
+			FOREIGN KEY(resolvedBy) REFERENCES users(idUser) ON DELETE SET NULL
+		);
+		CREATE INDEX reports_status_created_at ON reports(status, created_at, idReport);
+		CREATE INDEX reports_reporter ON reports(reporterID, idPost, idComment);
+		ALTER TABLE posts ADD COLUMN "hidden_at" TEXT;
+		ALTER TABLE comments ADD COLUMN "hidden_at" TEXT;
+		ALTER TABLE users ADD COLUMN "suspended_at" TEXT;
+		ALTER TABLE users ADD COLUMN "suspended_until" TEXT;
+		ALTER TABLE users ADD COLUMN "suspension_reason" TEXT NOT NULL DEFAULT '';`,
+		down: `ALTER TABLE users DROP COLUMN "suspension_reason";
+		ALTER TABLE users DROP COLUMN "suspended_until";
+		ALTER TABLE users DROP COLUMN "suspended_at";
+		ALTER TABLE comments DROP COLUMN "hidden_at";
+		ALTER TABLE posts DROP COLUMN "hidden_at";
+		DROP TABLE reports;`,
+	},
+	{
+		version: 19,
+		name:    "add_suspension_details",
+		up: `ALTER TABLE users ADD COLUMN "suspendedBy" INTEGER;
+		ALTER TABLE users ADD COLUMN "suspension_hides_content" INTEGER NOT NULL DEFAULT 0;`,
+		down: `ALTER TABLE users DROP COLUMN "suspension_hides_content";
+		ALTER TABLE users DROP COLUMN "suspendedBy";`,
+	},
+	{
+		version: 20,
+		name:    "create_password_resets",
+		up: `CREATE TABLE password_resets (
+			"token_hash" TEXT NOT NULL PRIMARY KEY,
+			"idUser" INTEGER NOT NULL,
+			"created_at" TEXT,
+			"expires_at" TEXT,
+			"used_at" TEXT,
+			FOREIGN KEY(idUser) REFERENCES users(idUser) ON DELETE CASCADE
+		);
+		CREATE INDEX password_resets_user ON password_resets(idUser);`,
+		down: `DROP TABLE password_resets;`,
+	},
+}
+
+// sqliteSearchTriggers keep the FTS5 indexes of migration 5 in sync with the
+// tables they index. Steps that rebuild those tables drop the triggers with
+// them; runMigration creates the missing ones again (see searchTriggers).
+const sqliteSearchTriggers = `
+		CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts(rowid, content_text) VALUES (new.idPost, new.content_text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, content_text) VALUES ('delete', old.idPost, old.content_text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF content_text ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, content_text) VALUES ('delete', old.idPost, old.content_text);
+			INSERT INTO posts_fts(rowid, content_text) VALUES (new.idPost, new.content_text);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+			INSERT INTO comments_fts(rowid, content_text) VALUES (new.idComment, new.content_text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+			INSERT INTO comments_fts(comments_fts, rowid, content_text) VALUES ('delete', old.idComment, old.content_text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content_text ON comments BEGIN
+			INSERT INTO comments_fts(comments_fts, rowid, content_text) VALUES ('delete', old.idComment, old.content_text);
+			INSERT INTO comments_fts(rowid, content_text) VALUES (new.idComment, new.content_text);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+			INSERT INTO users_fts(rowid, username, displayName) VALUES (new.idUser, new.username, new.displayName);
+		END;
+		CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+			INSERT INTO users_fts(users_fts, rowid, username, displayName) VALUES ('delete', old.idUser, old.username, old.displayName);
+		END;
+		CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF username, displayName ON users BEGIN
+			INSERT INTO users_fts(users_fts, rowid, username, displayName) VALUES ('delete', old.idUser, old.username, old.displayName);
+			INSERT INTO users_fts(rowid, username, displayName) VALUES (new.idUser, new.username, new.displayName);
+		END;`
+
+// rebuildContentTables recreates posts and comments, which is the only way
+// to change the foreign keys of a SQLite table. With cascade, deleting a post
+// deletes its comments and deleting a comment deletes its replies; without
+// it the tables are restored to their shape before migration 8. Rows, IDs
+// and indexes are preserved.
+func rebuildContentTables(cascade bool) string {
+	postRef := `FOREIGN KEY(idPost) REFERENCES posts(idPost)`
+	parentRef := ``
+	if cascade {
+		postRef += ` ON DELETE CASCADE`
+		parentRef = `,
+			FOREIGN KEY(parentCommentID) REFERENCES comments(idComment) ON DELETE CASCADE`
+	}
+	return `
+		CREATE TABLE posts_new (
+			"idPost" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"content_text" TEXT,
+			"created_at" TEXT,
+			"userID" INTEGER,
+			FOREIGN KEY(userID) REFERENCES users(idUser)
+		);
+		INSERT INTO posts_new (idPost, content_text, created_at, userID)
+			SELECT idPost, content_text, created_at, userID FROM posts;
+
+		CREATE TABLE comments_new (
+			"idComment" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"idPost" INTEGER,
+			"idUser" INTEGER,
+			"content_text" TEXT,
+			"created_at" TEXT,
+			"parentCommentID" INTEGER,
+			"edited_at" TEXT,
+			"deleted_at" TEXT,
+			` + postRef + `,
+			FOREIGN KEY(idUser) REFERENCES users(idUser)` + parentRef + `
+		);
+		INSERT INTO comments_new (idComment, idPost, idUser, content_text, created_at, parentCommentID, edited_at, deleted_at)
+			SELECT idComment, idPost, idUser, content_text, created_at, parentCommentID, edited_at, deleted_at FROM comments;
+
+		-- Carry the AUTOINCREMENT counters over, so that the IDs of deleted
+		-- rows are not handed out again
+		DELETE FROM sqlite_sequence WHERE name IN ('posts_new', 'comments_new');
+		UPDATE sqlite_sequence SET name = name || '_new' WHERE name IN ('posts', 'comments');
+
+		DROP TABLE comments;
+		DROP TABLE posts;
+		ALTER TABLE posts_new RENAME TO posts;
+		ALTER TABLE comments_new RENAME TO comments;
+
+		CREATE INDEX posts_created_at ON posts(created_at, idPost);
+		CREATE INDEX posts_user_created_at ON posts(userID, created_at, idPost);
+		CREATE INDEX comments_post_created_at ON comments(idPost, created_at, idComment);
+		CREATE INDEX comments_parent ON comments(parentCommentID);`
+}
+
+func ensureMigrationsTable(s *sqlStore) error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		"version" INTEGER NOT NULL PRIMARY KEY,
+		"name" TEXT NOT NULL,
+		"applied_at" TEXT NOT NULL
+	);`)
+	return err
+}
+
+// appliedMigrations returns the set of versions recorded in schema_migrations.
+func appliedMigrations(s *sqlStore) (map[int]bool, error) {
+	if err := ensureMigrationsTable(s); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes one migration step and updates schema_migrations in
+// the same transaction, so a failing step leaves no trace.
+func runMigration(s *sqlStore, m migration, up bool) error {
+	ctx := context.Background()
+	// PRAGMA foreign_keys is per connection and ignored inside transactions,
+	// so the step runs on a dedicated connection.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.disableForeignKeys {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	indexed := false
+	if m.searchIndex || m.searchTriggers {
+		if indexed, err = hasSearchIndex(tx); err != nil {
+			return err
+		}
+	}
+
+	if up {
+		skip := m.searchIndex && !s.fts5()
+		if m.adoptIf != "" {
+			err := tx.QueryRow(m.adoptIf).Scan(new(int))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+			}
+			skip = err == nil
+		}
+		if !skip {
+			if _, err := tx.Exec(m.up); err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+			}
+		}
+		_, err = tx.Exec(s.dialect.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+			m.version, m.name, formatTime(time.Now()))
+	} else {
+		if !m.searchIndex || indexed {
+			if _, err := tx.Exec(m.down); err != nil {
+				return fmt.Errorf("revert migration %d %s: %w", m.version, m.name, err)
+			}
+		}
+		_, err = tx.Exec(s.dialect.rebind(`DELETE FROM schema_migrations WHERE version = ?`), m.version)
+	}
+	if err != nil {
+		return err
+	}
+	if m.searchTriggers && indexed {
+		if _, err := tx.Exec(sqliteSearchTriggers); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+	}
+	if m.disableForeignKeys {
+		if err := checkForeignKeys(tx); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if any row violates a foreign key.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table string
+		var rowid sql.NullInt64
+		var parent string
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
+
+// hasSearchIndex reports whether the SQLite database has the FTS5 search
+// index of migration 5.
+func hasSearchIndex(tx *sql.Tx) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'posts_fts'`).Scan(&n)
+	return n > 0, err
+}
+
+// initSearchIndex matches the search of a SQLite store to the build. With
+// FTS5 it searches the FTS5 index, and creates it if migration 5 was applied
+// by a build without FTS5. Without FTS5 it matches substrings, unless the
+// database has the index: its triggers would make every write to posts fail
+// with "no such module: fts5".
+func initSearchIndex(s *sqlStore) error {
+	var fts5 bool
+	if err := s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return err
+	}
+	s.dialect = sqliteDialect{fts5: fts5}
+
+	applied, err := appliedMigrations(s)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	indexed, err := hasSearchIndex(tx)
+	if err != nil {
+		return err
+	}
+
+	if indexed && !fts5 {
+		return errors.New("the database has a full-text search index, which needs FTS5: rebuild with -tags sqlite_fts5")
+	}
+	for _, m := range sqliteMigrations {
+		if m.searchIndex && fts5 && !indexed && applied[m.version] {
+			if _, err := tx.Exec(m.up); err != nil {
+				return fmt.Errorf("create search index: %w", err)
+			}
+			log.Printf("Created the full-text search index of migration %d", m.version)
+		}
+	}
+	return tx.Commit()
+}
+
+// migrateUp applies every pending migration in version order.
+func migrateUp(s *sqlStore) error {
+	applied, err := appliedMigrations(s)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range s.dialect.migrations() {
+		if applied[m.version] {
+			continue
+		}
+		if err := runMigration(s, m, true); err != nil {
+			return err
+		}
+		fmt.Printf("Applied migration %d %s\n", m.version, m.name)
+	}
+	return nil
+}
+
+// migrateDown reverts the most recently applied steps migrations.
+func migrateDown(s *sqlStore, steps int) error {
+	applied, err := appliedMigrations(s)
+	if err != nil {
+		return err
+	}
+
+	migrations := s.dialect.migrations()
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if !applied[m.version] {
+			continue
+		}
+		if err := runMigration(s, m, false); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted migration %d %s\n", m.version, m.name)
+		steps--
+	}
+	return nil
+}
+
+func printMigrationStatus(s *sqlStore) error {
+	applied, err := appliedMigrations(s)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range s.dialect.migrations() {
+		state := "pending"
+		if applied[m.version] {
+			state = "applied"
+		}
+		fmt.Printf("%4d  %-8s %s\n", m.version, state, m.name)
+	}
+	return nil
+}
+
+// runMigrateCommand implements the "migrate" subcommand:
+//
+//	migrate [up]       apply all pending migrations
+//	migrate down [n]   revert the last n migrations (default 1)
+//	migrate status     list migrations and whether they are applied
+func runMigrateCommand(cfg Config, args []string) {
+	if cfg.Database == "memory" {
+		log.Fatal("migrate: the in-memory store has no schema to migrate")
+	}
+	store, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		err = migrateUp(store)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("migrate down: invalid step count %q", args[1])
+			}
+		}
+		err = migrateDown(store, steps)
+	case "status":
+		err = printMigrationStatus(store)
+	default:
+		fmt.Fprintln(os.Stderr, "usage: migrate [up | down [n] | status]")
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+// postgresMigrations mirrors sqliteMigrations version for version. Identifiers
+// are left unquoted, so Postgres folds them to lower case and the queries in
+// store_sql.go match them regardless of their camelCase spelling. Keys are
+// identity columns instead of AUTOINCREMENT and times are timestamptz
+// instead of RFC3339 text.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: `CREATE TABLE users (
+			idUser INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			username TEXT UNIQUE,
+			displayName TEXT UNIQUE,
+			email TEXT UNIQUE,
+			password TEXT
+		);
+		CREATE TABLE posts (
+			idPost INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			content_text TEXT,
+			created_at TIMESTAMPTZ,
+			userID INTEGER REFERENCES users(idUser)
+		);
+		CREATE TABLE comments (
+			idComment INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			idPost INTEGER REFERENCES posts(idPost),
+			idUser INTEGER REFERENCES users(idUser),
+			content_text TEXT,
+			created_at TIMESTAMPTZ
+		);`,
+		down: `DROP TABLE comments;
+		DROP TABLE posts;
+		DROP TABLE users;`,
+	},
+	{
+		version: 2,
+		name:    "create_sessions",
+		up: `CREATE TABLE sessions (
+			token_hash TEXT NOT NULL PRIMARY KEY,
+			idUser INTEGER NOT NULL REFERENCES users(idUser),
+			created_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ
+		);`,
+		down: `DROP TABLE sessions;`,
+	},
+	{
+		version: 3,
+		name:    "add_users_role",
+		up:      `ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';`,
+		down:    `ALTER TABLE users DROP COLUMN role;`,
+	},
+	{
+		version: 4,
+		name:    "paginate_by_created_at",
+		up: `CREATE INDEX posts_created_at ON posts(created_at, idPost);
+		CREATE INDEX posts_user_created_at ON posts(userID, created_at, idPost);
+		CREATE INDEX comments_post_created_at ON comments(idPost, created_at, idComment);`,
+		down: `DROP INDEX comments_post_created_at;
+		DROP INDEX posts_user_created_at;
+		DROP INDEX posts_created_at;`,
+	},
+	{
+		// Generated tsvector columns play the part of the SQLite FTS5 triggers.
+		version: 5,
+		name:    "create_search_index",
+		up: `ALTER TABLE posts ADD COLUMN search tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content_text, ''))) STORED;
+		ALTER TABLE comments ADD COLUMN search tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content_text, ''))) STORED;
+		ALTER TABLE users ADD COLUMN search tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', coalesce(username, '') || ' ' || coalesce(displayName, ''))) STORED;
+		CREATE INDEX posts_search ON posts USING GIN (search);
+		CREATE INDEX comments_search ON comments USING GIN (search);
+		CREATE INDEX users_search ON users USING GIN (search);`,
+		down: `ALTER TABLE users DROP COLUMN search;
+		ALTER TABLE comments DROP COLUMN search;
+		ALTER TABLE posts DROP COLUMN search;`,
+	},
+	{
+		version: 6,
+		name:    "add_comments_parent",
+		up: `ALTER TABLE comments ADD COLUMN parentCommentID INTEGER REFERENCES comments(idComment);
+		CREATE INDEX comments_parent ON comments(parentCommentID);`,
+		down: `ALTER TABLE comments DROP COLUMN parentCommentID;`,
+	},
+	{
+		version: 7,
+		name:    "add_comments_edited_deleted",
+		up: `ALTER TABLE comments ADD COLUMN edited_at TIMESTAMPTZ;
+		ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMPTZ;`,
+		down: `ALTER TABLE comments DROP COLUMN deleted_at;
+		ALTER TABLE comments DROP COLUMN edited_at;`,
+	},
+	{
+		// Postgres always enforced the keys; only the cascades are new.
+		version: 8,
+		name:    "enforce_foreign_keys",
+		up: `ALTER TABLE comments
+			DROP CONSTRAINT comments_idpost_fkey,
+			ADD CONSTRAINT comments_idpost_fkey FOREIGN KEY (idPost) REFERENCES posts(idPost) ON DELETE CASCADE,
+			DROP CONSTRAINT comments_parentcommentid_fkey,
+			ADD CONSTRAINT comments_parentcommentid_fkey FOREIGN KEY (parentCommentID) REFERENCES comments(idComment) ON DELETE CASCADE;`,
+		down: `ALTER TABLE comments
+			DROP CONSTRAINT comments_idpost_fkey,
+			ADD CONSTRAINT comments_idpost_fkey FOREIGN KEY (idPost) REFERENCES posts(idPost),
+			DROP CONSTRAINT comments_parentcommentid_fkey,
+			ADD CONSTRAINT comments_parentcommentid_fkey FOREIGN KEY (parentCommentID) REFERENCES comments(idComment);`,
+	},
+	{
+		version: 9,
+		name:    "add_posts_deleted_at",
+		up: `ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMPTZ;
+		CREATE INDEX posts_deleted_at ON posts(deleted_at) WHERE deleted_at IS NOT NULL;`,
+		down: `ALTER TABLE posts DROP COLUMN deleted_at;`,
+	},
+	{
+		version: 10,
+		name:    "create_post_revisions",
+		up: `CREATE TABLE post_revisions (
+			idPost INTEGER NOT NULL REFERENCES posts(idPost) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			editorID INTEGER NOT NULL REFERENCES users(idUser),
+			content_text TEXT,
+			created_at TIMESTAMPTZ,
+			PRIMARY KEY (idPost, revision)
+		);
+		INSERT INTO post_revisions (idPost, revision, editorID, content_text, created_at)
+			SELECT idPost, 1, userID, content_text, created_at FROM posts WHERE userID IS NOT NULL;`,
+		down: `DROP TABLE post_revisions;`,
+	},
+	{
+		version: 11,
+		name:    "create_follows_timelines",
+		up: `CREATE TABLE follows (
+			followerID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			followeeID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ,
+			PRIMARY KEY (followerID, followeeID)
+		);
+		CREATE INDEX follows_followee ON follows(followeeID, created_at);
+		CREATE INDEX follows_follower_created_at ON follows(followerID, created_at);
+		CREATE TABLE timeline_entries (
+			userID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			idPost INTEGER NOT NULL REFERENCES posts(idPost) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ,
+			PRIMARY KEY (userID, idPost)
+		);
+		CREATE INDEX timeline_entries_user_created_at ON timeline_entries(userID, created_at, idPost);
+		CREATE INDEX timeline_entries_post ON timeline_entries(idPost);`,
+		down: `DROP TABLE timeline_entries;
+		DROP TABLE follows;`,
+	},
+	{
+		version: 12,
+		name:    "create_reactions",
+		up: `CREATE TABLE reactions (
+			idReaction INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			idPost INTEGER REFERENCES posts(idPost) ON DELETE CASCADE,
+			idComment INTEGER REFERENCES comments(idComment) ON DELETE CASCADE,
+			idUser INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			reaction TEXT NOT NULL,
+			created_at TIMESTAMPTZ,
+			CHECK ((idPost IS NULL) <> (idComment IS NULL)),
+			UNIQUE (idPost, idUser, reaction),
+			UNIQUE (idComment, idUser, reaction)
+		);
+		CREATE INDEX reactions_post_created_at ON reactions(idPost, created_at, idReaction);
+		CREATE INDEX reactions_comment_created_at ON reactions(idComment, created_at, idReaction);`,
+		down: `DROP TABLE reactions;`,
+	},
+	{
+		version: 13,
+		name:    "create_notifications",
+		up: `CREATE TABLE notifications (
+			idNotification INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			userID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			idPost INTEGER REFERENCES posts(idPost) ON DELETE CASCADE,
+			idComment INTEGER REFERENCES comments(idComment) ON DELETE CASCADE,
+			actorID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			actorCount INTEGER NOT NULL,
+			updated_at TIMESTAMPTZ,
+			read_at TIMESTAMPTZ
+		);
+		CREATE INDEX notifications_user_updated_at ON notifications(userID, updated_at, idNotification);
+		CREATE INDEX notifications_unread ON notifications(userID, kind) WHERE read_at IS NULL;
+		CREATE INDEX notifications_post ON notifications(idPost);
+		CREATE INDEX notifications_comment ON notifications(idComment);
+		CREATE TABLE notification_actors (
+			idNotification INTEGER NOT NULL REFERENCES notifications(idNotification) ON DELETE CASCADE,
+			actorID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			PRIMARY KEY (idNotification, actorID)
+		);`,
+		down: `DROP TABLE notification_actors;
+		DROP TABLE notifications;`,
+	},
+	{
+		version: 14,
+		name:    "create_mentions_hashtags",
+		up: `CREATE TABLE mentions (
+			userID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			idPost INTEGER NOT NULL REFERENCES posts(idPost) ON DELETE CASCADE,
+			idComment INTEGER REFERENCES comments(idComment) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ
+		);
+		CREATE INDEX mentions_post ON mentions(idPost, idComment);
+		CREATE INDEX mentions_comment ON mentions(idComment);
+		CREATE INDEX mentions_user ON mentions(userID, created_at);
+		CREATE TABLE hashtags (
+			tag TEXT NOT NULL,
+			idPost INTEGER NOT NULL REFERENCES posts(idPost) ON DELETE CASCADE,
+			idComment INTEGER REFERENCES comments(idComment) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ
+		);
+		CREATE INDEX hashtags_tag ON hashtags(tag, idPost);
+		CREATE INDEX hashtags_created_at ON hashtags(created_at);
+		CREATE INDEX hashtags_post ON hashtags(idPost, idComment);
+		CREATE INDEX hashtags_comment ON hashtags(idComment);`,
+		down: `DROP TABLE hashtags;
+		DROP TABLE mentions;`,
+	},
+	{
+		version: 15,
+		name:    "create_attachments",
+		up: `CREATE TABLE attachments (
+			idAttachment INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			idPost INTEGER NOT NULL REFERENCES posts(idPost) ON DELETE CASCADE,
+			blob_key TEXT NOT NULL,
+			thumbnail_key TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			alt_text TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ
+		);
+		CREATE INDEX attachments_post ON attachments(idPost, idAttachment);`,
+		down: `DROP TABLE attachments;`,
+	},
+	{
+		version: 16,
+		name:    "create_conversations_messages",
+		up: `CREATE TABLE conversations (
+			idConversation INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			direct_key TEXT UNIQUE,
+			created_at TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ
+		);
+		CREATE TABLE conversation_members (
+			idConversation INTEGER NOT NULL REFERENCES conversations(idConversation) ON DELETE CASCADE,
+			userID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			joined_at TIMESTAMPTZ,
+			last_read_message_id INTEGER NOT NULL DEFAULT 0,
+			read_at TIMESTAMPTZ,
+			PRIMARY KEY (idConversation, userID)
+		);
+		CREATE INDEX conversation_members_user ON conversation_members(userID);
+		CREATE TABLE messages (
+			idMessage INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			idConversation INTEGER NOT NULL REFERENCES conversations(idConversation) ON DELETE CASCADE,
+			senderID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			content_text TEXT NOT NULL,
+			created_at TIMESTAMPTZ
+		);
+		CREATE INDEX messages_conversation_created_at ON messages(idConversation, created_at, idMessage);`,
+		down: `DROP TABLE messages;
+		DROP TABLE conversation_members;
+		DROP TABLE conversations;`,
+	},
+	{
+		version: 17,
+		name:    "create_restrictions",
+		up: `CREATE TABLE restrictions (
+			userID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			targetID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			created_at TIMESTAMPTZ,
+			PRIMARY KEY (userID, targetID, kind)
+		);
+		CREATE INDEX restrictions_user_kind_created_at ON restrictions(userID, kind, created_at);`,
+		down: `DROP TABLE restrictions;`,
+	},
+	{
+		version: 18,
+		name:    "create_reports_moderation",
+		up: `CREATE TABLE reports (
+			idReport INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			reporterID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			idPost INTEGER NOT NULL,
+			idComment INTEGER,
+			authorID INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			content_text TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at TIMESTAMPTZ,
+			claimedBy INTEGER REFERENCES users(idUser) ON DELETE SET NULL,
+			claimed_at TIMESTAMPTZ,
+			resolvedBy INTEGER REFERENCES users(idUser) ON DELETE SET NULL,
+			resolved_at TIMESTAMPTZ,
+			action TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX reports_status_created_at ON reports(status, created_at, idReport);
+		CREATE INDEX reports_reporter ON reports(reporterID, idPost, idComment);
+		ALTER TABLE posts ADD COLUMN hidden_at TIMESTAMPTZ;
+		ALTER TABLE comments ADD COLUMN hidden_at TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN suspended_until TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '';`,
+		down: `ALTER TABLE users DROP COLUMN suspension_reason;
+		ALTER TABLE users DROP COLUMN suspended_until;
+		ALTER TABLE users DROP COLUMN suspended_at;
+		ALTER TABLE comments DROP COLUMN hidden_at;
+		ALTER TABLE posts DROP COLUMN hidden_at;
+		DROP TABLE reports;`,
+	},
+	{
+		version: 19,
+		name:    "add_suspension_details",
+		up: `ALTER TABLE users ADD COLUMN suspendedBy INTEGER REFERENCES users(idUser) ON DELETE SET NULL;
+		ALTER TABLE users ADD COLUMN suspension_hides_content BOOLEAN NOT NULL DEFAULT FALSE;`,
+		down: `ALTER TABLE users DROP COLUMN suspension_hides_content;
+		ALTER TABLE users DROP COLUMN suspendedBy;`,
+	},
+	{
+		version: 20,
+		name:    "create_password_resets",
+		up: `CREATE TABLE password_resets (
+			token_hash TEXT NOT NULL PRIMARY KEY,
+			idUser INTEGER NOT NULL REFERENCES users(idUser) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ,
+			used_at TIMESTAMPTZ
+		);
+		CREATE INDEX password_resets_user ON password_resets(idUser);`,
+		down: `DROP TABLE password_resets;`,
+	},
+}
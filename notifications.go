@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NotificationKind is the event a notification is about.
+type NotificationKind string
+
+const (
+	// NotifyComment: someone commented on the recipient's post.
+	NotifyComment NotificationKind = "comment"
+	// NotifyReply: someone replied to the recipient's comment.
+	NotifyReply NotificationKind = "reply"
+	// NotifyReaction: someone reacted to the recipient's post or comment.
+	NotifyReaction NotificationKind = "reaction"
+	// NotifyFollow: someone followed the recipient.
+	NotifyFollow NotificationKind = "follow"
+	// NotifyMention: someone mentioned the recipient in a post or comment.
+	NotifyMention NotificationKind = "mention"
+)
+
+// Notification is one entry of GET /notifications. Events of the same kind
+// about the same target are coalesced while the notification is unread:
+// ActorCount is the number of distinct users behind them and ActorID the
+// most recent one, so clients can render "alice and 4 others liked your
+// post".
+type Notification struct {
+	IDNotification int              `json:"idNotification"`
+	Kind           NotificationKind `json:"kind"`
+	// PostID and CommentID are the target, or 0 if the kind has none.
+	PostID     int    `json:"idPost,omitempty"`
+	CommentID  int    `json:"idComment,omitempty"`
+	ActorID    int    `json:"actorID"`
+	ActorName  string `json:"actorName"`
+	ActorCount int    `json:"actorCount"`
+	// UpdatedAt is the time of the latest event.
+	UpdatedAt string  `json:"updated_at"`
+	ReadAt    *string `json:"read_at"`
+}
+
+func notificationCursor(n Notification) Cursor {
+	return Cursor{CreatedAt: n.UpdatedAt, ID: n.IDNotification}
+}
+
+// NotificationEvent is a domain event to notify UserID of, and what GET
+// /stream pushes to them.
+type NotificationEvent struct {
+	UserID    int              `json:"-"`
+	Kind      NotificationKind `json:"kind"`
+	PostID    int              `json:"idPost,omitempty"`
+	CommentID int              `json:"idComment,omitempty"`
+	ActorID   int              `json:"actorID"`
+	At        time.Time        `json:"-"`
+}
+
+// notify records an event. Users are not notified of their own actions.
+// Notifications are a side effect of a request that has already succeeded,
+// so failures are only logged.
+func (s *Server) notify(ctx context.Context, e NotificationEvent) {
+	if e.UserID == e.ActorID {
+		return
+	}
+	// Blocked and muted users go unheard, without being told
+	restricted, err := s.store.Restricts(ctx, e.UserID, e.ActorID, "")
+	if err != nil {
+		log.Printf("notifications: %s for user %d: %v", e.Kind, e.UserID, err)
+		return
+	}
+	if restricted {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if err := s.store.Notify(ctx, e); err != nil {
+		log.Printf("notifications: %s for user %d: %v", e.Kind, e.UserID, err)
+		return
+	}
+	s.hub.Publish(EventNotification, e, topicUser(e.UserID))
+}
+
+// notifyComment tells the author of the post, and of the parent comment for
+// replies, about a new comment. An author who is both only gets the reply.
+func (s *Server) notifyComment(ctx context.Context, post Post, parent *Comment, comment Comment) {
+	if parent != nil {
+		s.notify(ctx, NotificationEvent{
+			UserID:    parent.IDUser,
+			Kind:      NotifyReply,
+			PostID:    post.IDPost,
+			CommentID: parent.IDComment,
+			ActorID:   comment.IDUser,
+		})
+		if parent.IDUser == post.UserID {
+			return
+		}
+	}
+	s.notify(ctx, NotificationEvent{
+		UserID:  post.UserID,
+		Kind:    NotifyComment,
+		PostID:  post.IDPost,
+		ActorID: comment.IDUser,
+	})
+}
+
+// notifyMentions tells users that actorID mentioned them in a post, or in a
+// comment if commentID is not 0.
+func (s *Server) notifyMentions(ctx context.Context, actorID, postID, commentID int, userIDs []int) {
+	for _, userID := range userIDs {
+		s.notify(ctx, NotificationEvent{
+			UserID:    userID,
+			Kind:      NotifyMention,
+			PostID:    postID,
+			CommentID: commentID,
+			ActorID:   actorID,
+		})
+	}
+}
+
+// GetNotifications handles GET /notifications?unread=: the caller's
+// notifications, most recently active first.
+func (s *Server) GetNotifications(c echo.Context) error {
+	unreadOnly := false
+	if value := c.QueryParam("unread"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "unread must be true or false"})
+		}
+		unreadOnly = b
+	}
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	notifications, err := s.store.ListNotifications(c.Request().Context(), currentUser(c).IDUser, unreadOnly, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query notifications"})
+	}
+	return c.JSON(http.StatusOK, newPage(notifications, page, notificationCursor))
+}
+
+// GetUnreadNotificationCount handles GET /notifications/unreadCount.
+func (s *Server) GetUnreadNotificationCount(c echo.Context) error {
+	count, err := s.store.UnreadNotificationCount(c.Request().Context(), currentUser(c).IDUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query notifications"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"count": count})
+}
+
+func (s *Server) ReadNotification(c echo.Context) error {
+	type ReadRequest struct {
+		NotificationID string `json:"notificationID"`
+	}
+
+	var req ReadRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	notificationID, ok := parseID(req.NotificationID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Notification ID is required"})
+	}
+
+	// Other users' notifications are reported as missing
+	err := s.store.MarkNotificationRead(c.Request().Context(), currentUser(c).IDUser, notificationID, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update notification: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Notification marked as read"})
+}
+
+func (s *Server) ReadAllNotifications(c echo.Context) error {
+	count, err := s.store.MarkAllNotificationsRead(c.Request().Context(), currentUser(c).IDUser, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update notifications: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Notifications marked as read",
+		"count":   count,
+	})
+}
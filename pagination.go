@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SortOrder is the direction of a list endpoint, chosen with ?sort=.
+type SortOrder string
+
+const (
+	SortNewest SortOrder = "newest"
+	SortOldest SortOrder = "oldest"
+	// SortRelevance is the only order of search results. Scores are not
+	// stable keys, so these pages are addressed by offset instead.
+	SortRelevance SortOrder = "relevance"
+)
+
+// Cursor is the position of the last item of a page. Clients only ever see
+// it base64 encoded and must treat it as opaque.
+type Cursor struct {
+	Sort      SortOrder `json:"s"`
+	CreatedAt string    `json:"c,omitempty"`
+	ID        int       `json:"i,omitempty"`
+	Offset    int       `json:"o,omitempty"`
+}
+
+func (c Cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if c.ID <= 0 && c.Offset <= 0 {
+		return nil, errors.New("cursor without position")
+	}
+	return &c, nil
+}
+
+// PageRequest selects one page of a list ordered by (created_at, id), or by
+// id alone for rows without a creation time.
+type PageRequest struct {
+	Limit int
+	Sort  SortOrder
+	// After is the last item of the previous page; nil for the first page.
+	After *Cursor
+}
+
+// probe returns the request for one extra item, which tells whether another
+// page follows without a separate count query.
+func (p PageRequest) probe() PageRequest {
+	p.Limit++
+	return p
+}
+
+// offset is the number of items to skip for offset-addressed pages.
+func (p PageRequest) offset() int {
+	if p.After == nil {
+		return 0
+	}
+	return p.After.Offset
+}
+
+// Page is the JSON envelope of every paginated endpoint.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// newPage trims the result of a probe() request to the requested size and
+// derives the cursor of the next page from the last item kept.
+func newPage[T any](items []T, page PageRequest, cursorOf func(T) Cursor) Page[T] {
+	result := Page[T]{Items: items}
+	if result.Items == nil {
+		result.Items = []T{}
+	}
+	if len(items) > page.Limit {
+		result.Items = items[:page.Limit]
+		cursor := cursorOf(result.Items[page.Limit-1])
+		cursor.Sort = page.Sort
+		next := cursor.encode()
+		result.NextCursor = &next
+	}
+	return result
+}
+
+func postCursor(p Post) Cursor             { return Cursor{CreatedAt: p.CreatedAt, ID: p.IDPost} }
+func commentCursor(c Comment) Cursor       { return Cursor{CreatedAt: c.CreatedAt, ID: c.IDComment} }
+func userCursor(u User) Cursor             { return Cursor{ID: u.IDUser} }
+func revisionCursor(r PostRevision) Cursor { return Cursor{ID: r.Revision} }
+
+// parsePageRequest reads ?limit=, ?cursor= and ?sort= and writes a 400
+// response if any of them is invalid. Limits above the configured maximum
+// are capped rather than rejected.
+func (s *Server) parsePageRequest(c echo.Context, defaultSort SortOrder) (PageRequest, bool, error) {
+	page := PageRequest{Limit: s.cfg.DefaultPageSize, Sort: defaultSort}
+
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "limit must be a positive integer"})
+		}
+		page.Limit = min(limit, s.cfg.MaxPageSize)
+	}
+
+	if sort := SortOrder(c.QueryParam("sort")); sort != "" {
+		switch {
+		case defaultSort == SortRelevance && sort != SortRelevance:
+			return page, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "sort must be relevance"})
+		case defaultSort != SortRelevance && sort != SortNewest && sort != SortOldest:
+			return page, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "sort must be newest or oldest"})
+		}
+		page.Sort = sort
+	}
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil || cursor.Sort != page.Sort {
+			return page, false, c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid cursor"})
+		}
+		page.After = cursor
+	}
+
+	return page, true, nil
+}
+
+// collectAll walks every page of a list. It is meant for maintenance code
+// such as the seeding helpers, never for request handlers.
+func collectAll[T any](fetch func(PageRequest) ([]T, error), cursorOf func(T) Cursor) ([]T, error) {
+	var all []T
+	page := PageRequest{Limit: 500, Sort: SortOldest}
+	for {
+		items, err := fetch(page.probe())
+		if err != nil {
+			return nil, err
+		}
+		p := newPage(items, page, cursorOf)
+		all = append(all, p.Items...)
+		if p.NextCursor == nil {
+			return all, nil
+		}
+		last := cursorOf(p.Items[len(p.Items)-1])
+		last.Sort = page.Sort
+		page.After = &last
+	}
+}
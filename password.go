@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword returns the bcrypt hash of password using the given cost.
+func hashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash reports whether stored looks like a bcrypt hash rather than
+// a legacy plaintext password.
+func isPasswordHash(stored string) bool {
+	if !strings.HasPrefix(stored, "$2a$") && !strings.HasPrefix(stored, "$2b$") && !strings.HasPrefix(stored, "$2y$") {
+		return false
+	}
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// verifyPassword checks password against the value stored in the users table.
+// Rows created before hashing was introduced still hold the plaintext, so
+// those are compared in constant time instead. needsRehash is true when the
+// password matched but the stored value is plaintext or was hashed with a
+// different cost than cost.
+func verifyPassword(stored, password string, cost int) (ok bool, needsRehash bool) {
+	if !isPasswordHash(stored) {
+		ok = stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	storedCost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || storedCost != cost
+}
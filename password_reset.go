@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ForgotPassword handles POST /password/forgot {email}: it emails a single
+// use link to reset the password of the account with that address. The
+// response is the same whether or not there is such an account, and the
+// email is sent in the background, so neither its content nor its timing
+// tells which addresses are registered.
+func (s *Server) ForgotPassword(c echo.Context) error {
+	type ForgotRequest struct {
+		Email string `json:"email"`
+	}
+
+	var req ForgotRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Email is required"})
+	}
+
+	ctx := c.Request().Context()
+	user, err := s.store.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
+	}
+	if err == nil {
+		token, tokenHash, err := newToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create reset token"})
+		}
+		now := time.Now()
+		expiresAt := now.Add(s.cfg.PasswordResetTTL)
+		if err := s.store.CreatePasswordReset(ctx, tokenHash, user.IDUser, now, expiresAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create reset token"})
+		}
+
+		mail := s.passwordResetMail(user, token, expiresAt)
+		go func() {
+			if err := s.mailer.Send(context.Background(), mail); err != nil {
+				log.Printf("failed to send password reset email to user %d: %v", user.IDUser, err)
+			}
+		}()
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "If an account uses this email, a password reset link was sent to it"})
+}
+
+// passwordResetMail is the email that hands token to user.
+func (s *Server) passwordResetMail(user User, token string, expiresAt time.Time) Mail {
+	how, link := "enter this reset token", token
+	if s.cfg.PasswordResetURL != "" {
+		how, link = "open this link", s.cfg.PasswordResetURL+"?token="+url.QueryEscape(token)
+	}
+	return Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hello " + user.DisplayName + ",\n\n" +
+			"someone asked to reset the password of your account " + user.Username + ". " +
+			"To choose a new password, " + how + ":\n\n" +
+			"    " + link + "\n\n" +
+			"It can be used once and expires at " + expiresAt.UTC().Format(time.RFC1123) + ".\n" +
+			"If you did not ask for this, you can ignore this email; your password stays the same.\n",
+	}
+}
+
+// ResetPassword handles POST /password/reset {token, password}. It uses up
+// the token and signs the user out everywhere.
+func (s *Server) ResetPassword(c echo.Context) error {
+	type ResetRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var req ResetRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Token is required"})
+	}
+	if msg := checkPasswordStrength(req.Password); msg != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": msg})
+	}
+
+	hash, err := hashPassword(req.Password, s.cfg.BcryptCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to hash password"})
+	}
+	_, err = s.store.ResetPassword(c.Request().Context(), hashToken(req.Token), hash, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Reset token is invalid or has expired"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reset password: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Password reset successfully"})
+}
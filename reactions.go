@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReactionTarget is the kind of row a reaction belongs to.
+type ReactionTarget string
+
+const (
+	ReactToPost    ReactionTarget = "post"
+	ReactToComment ReactionTarget = "comment"
+)
+
+// Reaction is one entry of GET /reactions.
+type Reaction struct {
+	IDReaction  int    `json:"idReaction"`
+	UserID      int    `json:"userID"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Reaction    string `json:"reaction"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func reactionCursor(r Reaction) Cursor { return Cursor{CreatedAt: r.CreatedAt, ID: r.IDReaction} }
+
+// ReactionSummary is the reaction count of a target per type, and the types
+// the viewer reacted with.
+type ReactionSummary struct {
+	Counts  map[string]int
+	Reacted []string
+}
+
+// ReactionPage is the response of GET /reactions: one page of reactions
+// plus the counts over all of them.
+type ReactionPage struct {
+	Page[Reaction]
+	Counts map[string]int `json:"counts"`
+}
+
+// reactionRequest is the body of POST /addReaction and DELETE
+// /removeReaction. Exactly one of PostID and CommentID must be set.
+type reactionRequest struct {
+	PostID    string `json:"postID"`
+	CommentID string `json:"commentID"`
+	Reaction  string `json:"reaction"`
+}
+
+// parseReactionTarget picks the target out of a post and a comment ID, of
+// which exactly one must be given.
+func parseReactionTarget(postID, commentID string) (ReactionTarget, int, bool) {
+	if (postID == "") == (commentID == "") {
+		return "", 0, false
+	}
+	if postID != "" {
+		id, ok := parseID(postID)
+		return ReactToPost, id, ok
+	}
+	id, ok := parseID(commentID)
+	return ReactToComment, id, ok
+}
+
+// bindReaction reads a reactionRequest and writes a 400 response if it is
+// invalid.
+func (s *Server) bindReaction(c echo.Context) (ReactionTarget, int, string, bool, error) {
+	var req reactionRequest
+	if err := c.Bind(&req); err != nil {
+		return "", 0, "", false, c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	target, targetID, ok := parseReactionTarget(req.PostID, req.CommentID)
+	if !ok {
+		return "", 0, "", false, c.JSON(http.StatusBadRequest, echo.Map{"error": "Either a post ID or a comment ID is required"})
+	}
+	if !slices.Contains(s.cfg.ReactionTypes, req.Reaction) {
+		return "", 0, "", false, c.JSON(http.StatusBadRequest, echo.Map{
+			"error":   "Unknown reaction",
+			"allowed": s.cfg.ReactionTypes,
+		})
+	}
+	return target, targetID, req.Reaction, true, nil
+}
+
+// reactionTarget looks up a post or comment to react to. It reports the
+// author and the post the target belongs to, or exists false if it is
+// missing, soft-deleted or a tombstone.
+func (s *Server) reactionTarget(ctx context.Context, target ReactionTarget, targetID int) (authorID, postID int, exists bool, err error) {
+	if target == ReactToPost {
+		var post Post
+		post, err = s.store.GetPost(ctx, targetID)
+		authorID, postID = post.UserID, post.IDPost
+	} else {
+		var comment Comment
+		comment, err = s.store.GetComment(ctx, targetID)
+		if err == nil && comment.Deleted {
+			err = ErrNotFound
+		}
+		authorID, postID = comment.IDUser, comment.IDPost
+	}
+	if errors.Is(err, ErrNotFound) {
+		return 0, 0, false, nil
+	}
+	return authorID, postID, err == nil, err
+}
+
+// missingTarget is the *ReferenceError for a target that does not exist.
+func missingTarget(target ReactionTarget) *ReferenceError {
+	if target == ReactToPost {
+		return &ReferenceError{Field: "postID"}
+	}
+	return &ReferenceError{Field: "commentID"}
+}
+
+func (s *Server) AddReaction(c echo.Context) error {
+	target, targetID, reaction, ok, err := s.bindReaction(c)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	authorID, postID, exists, err := s.reactionTarget(ctx, target, targetID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query " + string(target)})
+	}
+	if !exists {
+		return unprocessableReference(c, missingTarget(target))
+	}
+
+	userID := currentUser(c).IDUser
+	if ok, err := s.refuseBlocked(c, userID, "You cannot react to this "+string(target), authorID); !ok {
+		return err
+	}
+	err = s.store.AddReaction(ctx, target, targetID, userID, reaction, time.Now())
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to add reaction: " + err.Error()})
+	}
+	event := NotificationEvent{UserID: authorID, Kind: NotifyReaction, PostID: postID, ActorID: userID}
+	if target == ReactToComment {
+		event.CommentID = targetID
+	}
+	s.notify(ctx, event)
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Reaction added successfully"})
+}
+
+func (s *Server) RemoveReaction(c echo.Context) error {
+	target, targetID, reaction, ok, err := s.bindReaction(c)
+	if !ok {
+		return err
+	}
+
+	err = s.store.RemoveReaction(c.Request().Context(), target, targetID, currentUser(c).IDUser, reaction)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Reaction not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to remove reaction: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Reaction removed successfully"})
+}
+
+// GetReactions handles GET /reactions?postID=|commentID=&reaction=: who
+// reacted to the target, newest first, optionally of one type only.
+func (s *Server) GetReactions(c echo.Context) error {
+	target, targetID, ok := parseReactionTarget(c.QueryParam("postID"), c.QueryParam("commentID"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Either a post ID or a comment ID is required"})
+	}
+	reaction := c.QueryParam("reaction")
+	if reaction != "" && !slices.Contains(s.cfg.ReactionTypes, reaction) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unknown reaction", "allowed": s.cfg.ReactionTypes})
+	}
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	_, _, exists, err := s.reactionTarget(ctx, target, targetID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query " + string(target)})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": referenceMessages[missingTarget(target).Field]})
+	}
+
+	reactions, err := s.store.ListReactions(ctx, target, targetID, reaction, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query reactions"})
+	}
+	summaries, err := s.store.ReactionSummaries(ctx, target, []int{targetID}, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query reactions"})
+	}
+	counts := summaries[targetID].Counts
+	if counts == nil {
+		counts = map[string]int{}
+	}
+
+	return c.JSON(http.StatusOK, ReactionPage{Page: newPage(reactions, page, reactionCursor), Counts: counts})
+}
+
+// GetReactionTypes handles GET /reactionTypes: the configured reaction set.
+func (s *Server) GetReactionTypes(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.cfg.ReactionTypes)
+}
+
+// withReactions fills in the reaction counts of posts, and the reactions of
+// the caller if there is one.
+func (s *Server) withReactions(c echo.Context, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.IDPost
+	}
+
+	summaries, err := s.store.ReactionSummaries(c.Request().Context(), ReactToPost, ids, viewerID(c))
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		summary := summaries[posts[i].IDPost]
+		posts[i].Reactions = summary.Counts
+		posts[i].Reacted = summary.Reacted
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after the 72nd byte
+	maxPasswordBytes = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.]{3,32}$`)
+
+type RegisterRequest struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+}
+
+// validate returns a map of field name to error message for every invalid
+// field of the request. An empty map means the request is valid.
+func (r *RegisterRequest) validate() map[string]string {
+	fields := map[string]string{}
+
+	if msg := checkUsername(r.Username); msg != "" {
+		fields["username"] = msg
+	}
+
+	if msg := checkDisplayName(r.DisplayName); msg != "" {
+		fields["displayName"] = msg
+	}
+
+	if msg := checkEmail(r.Email); msg != "" {
+		fields["email"] = msg
+	}
+
+	if msg := checkPasswordStrength(r.Password); msg != "" {
+		fields["password"] = msg
+	}
+
+	return fields
+}
+
+// checkUsername returns why username is not acceptable, or an empty string.
+// The pattern leaves out '@', so a username can never be mistaken for the
+// email address that also logs in.
+func checkUsername(username string) string {
+	if !usernamePattern.MatchString(username) {
+		return "Username must be 3-32 characters of letters, digits, '_' or '.'"
+	}
+	return ""
+}
+
+func checkDisplayName(displayName string) string {
+	if n := utf8.RuneCountInString(displayName); n == 0 || n > 64 {
+		return "Display name must be between 1 and 64 characters"
+	}
+	return ""
+}
+
+func checkEmail(email string) string {
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "Email address is not valid"
+	}
+	return ""
+}
+
+// checkPasswordStrength returns a human readable reason why password is too
+// weak, or an empty string if it is acceptable.
+func checkPasswordStrength(password string) string {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return "Password must be at least 8 characters long"
+	}
+	if len(password) > maxPasswordBytes {
+		return "Password must be at most 72 bytes long"
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return "Password must contain both letters and digits"
+	}
+	return ""
+}
+
+func (s *Server) Register(c echo.Context) error {
+	var req RegisterRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Email = strings.TrimSpace(req.Email)
+
+	// Validate input
+	if fields := req.validate(); len(fields) > 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":  "Invalid registration data",
+			"fields": fields,
+		})
+	}
+
+	hash, err := hashPassword(req.Password, s.cfg.BcryptCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	// Insert user into the store
+	user := User{
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		Role:        RoleUser,
+	}
+	err = s.store.CreateUser(c.Request().Context(), &user, hash)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error":  "Account already exists",
+			"fields": map[string]string{conflict.Field: "This " + conflict.Field + " is already taken"},
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to create user",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUpdateUserRequestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		req  UpdateUserRequest
+		want []string
+	}{
+		{"nothing to change", UpdateUserRequest{}, nil},
+		{"valid fields", UpdateUserRequest{Username: "alice_2", DisplayName: "Alice", Email: "alice@example.com"}, nil},
+		{"username like an email", UpdateUserRequest{Username: "bob@example.com"}, []string{"username"}},
+		{"short username", UpdateUserRequest{Username: "al"}, []string{"username"}},
+		{"long display name", UpdateUserRequest{DisplayName: string(make([]byte, 65))}, []string{"displayName"}},
+		{"invalid email", UpdateUserRequest{Email: "Alice <alice@example.com>"}, []string{"email"}},
+		{"several invalid", UpdateUserRequest{Username: "a b", Email: "nope"}, []string{"email", "username"}},
+	} {
+		var got []string
+		for field := range c.req.validate() {
+			got = append(got, field)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: invalid fields = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
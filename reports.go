@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxReportDetailsLength caps the details of reports and the notes of
+// moderators, in characters.
+const maxReportDetailsLength = 1000
+
+// ReportReason is the category a reporter picks for a report.
+type ReportReason string
+
+const (
+	ReasonSpam           ReportReason = "spam"
+	ReasonHarassment     ReportReason = "harassment"
+	ReasonHate           ReportReason = "hate"
+	ReasonViolence       ReportReason = "violence"
+	ReasonSexual         ReportReason = "sexual"
+	ReasonMisinformation ReportReason = "misinformation"
+	ReasonOther          ReportReason = "other"
+)
+
+var reportReasons = []ReportReason{
+	ReasonSpam, ReasonHarassment, ReasonHate, ReasonViolence, ReasonSexual, ReasonMisinformation, ReasonOther,
+}
+
+// ReportStatus is where a report is in the moderation queue.
+type ReportStatus string
+
+const (
+	ReportOpen     ReportStatus = "open"
+	ReportClaimed  ReportStatus = "claimed"
+	ReportResolved ReportStatus = "resolved"
+)
+
+var reportStatuses = []ReportStatus{ReportOpen, ReportClaimed, ReportResolved}
+
+// ModerationAction is the decision that resolves a report.
+type ModerationAction string
+
+const (
+	// ActionDismiss leaves the content alone.
+	ActionDismiss ModerationAction = "dismiss"
+	// ActionHide takes the content out of view for good; see HidePost and
+	// HideComment.
+	ActionHide ModerationAction = "hide"
+	// ActionDelete deletes the content as its author could.
+	ActionDelete ModerationAction = "delete"
+	// ActionSuspend suspends the author of the content.
+	ActionSuspend ModerationAction = "suspend"
+)
+
+var moderationActions = []ModerationAction{ActionDismiss, ActionHide, ActionDelete, ActionSuspend}
+
+// Report is a complaint about a post or comment. It keeps a copy of the
+// reported text, which may have been edited or deleted since.
+type Report struct {
+	IDReport int `json:"idReport"`
+	PostID   int `json:"idPost"`
+	// CommentID is set for reports of comments, which also carry the post
+	// the comment belongs to.
+	CommentID   *int         `json:"idComment"`
+	ReporterID  int          `json:"reporterID"`
+	AuthorID    int          `json:"authorID"`
+	ContentText string       `json:"content_text"`
+	Reason      ReportReason `json:"reason"`
+	Details     string       `json:"details"`
+	Status      ReportStatus `json:"status"`
+	CreatedAt   string       `json:"created_at"`
+	// ClaimedBy is the moderator working on the report, ResolvedBy the one
+	// who decided it.
+	ClaimedBy  *int             `json:"claimedBy"`
+	ClaimedAt  *string          `json:"claimed_at"`
+	ResolvedBy *int             `json:"resolvedBy"`
+	ResolvedAt *string          `json:"resolved_at"`
+	Action     ModerationAction `json:"action,omitempty"`
+	Note       string           `json:"note,omitempty"`
+}
+
+func reportCursor(r Report) Cursor { return Cursor{CreatedAt: r.CreatedAt, ID: r.IDReport} }
+
+// reportedContent looks up a post or comment to report and returns a report
+// of it. exists is false if it is missing, soft-deleted or a tombstone.
+func (s *Server) reportedContent(ctx context.Context, target ReactionTarget, targetID int) (report Report, exists bool, err error) {
+	if target == ReactToPost {
+		var post Post
+		post, err = s.store.GetPost(ctx, targetID)
+		report = Report{PostID: post.IDPost, AuthorID: post.UserID, ContentText: post.ContentText}
+	} else {
+		var comment Comment
+		comment, err = s.store.GetComment(ctx, targetID)
+		if err == nil && comment.Deleted {
+			err = ErrNotFound
+		}
+		report = Report{PostID: comment.IDPost, CommentID: &comment.IDComment, AuthorID: comment.IDUser, ContentText: comment.ContentText}
+	}
+	if errors.Is(err, ErrNotFound) {
+		return report, false, nil
+	}
+	return report, err == nil, err
+}
+
+// AddReport handles POST /report {postID | commentID, reason, details}.
+func (s *Server) AddReport(c echo.Context) error {
+	type ReportRequest struct {
+		PostID    string       `json:"postID"`
+		CommentID string       `json:"commentID"`
+		Reason    ReportReason `json:"reason"`
+		Details   string       `json:"details"`
+	}
+
+	var req ReportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	target, targetID, ok := parseReactionTarget(req.PostID, req.CommentID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Either a post ID or a comment ID is required"})
+	}
+	if !slices.Contains(reportReasons, req.Reason) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":   "Unknown reason",
+			"allowed": reportReasons,
+		})
+	}
+	if len([]rune(req.Details)) > maxReportDetailsLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Details must not be longer than " + strconv.Itoa(maxReportDetailsLength) + " characters"})
+	}
+
+	ctx := c.Request().Context()
+	report, exists, err := s.reportedContent(ctx, target, targetID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query " + string(target)})
+	}
+	if !exists {
+		return unprocessableReference(c, missingTarget(target))
+	}
+	reporterID := currentUser(c).IDUser
+	if report.AuthorID == reporterID {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "You cannot report your own " + string(target)})
+	}
+
+	report.ReporterID = reporterID
+	report.Reason = req.Reason
+	report.Details = req.Details
+	report.Status = ReportOpen
+	report.CreatedAt = formatTime(time.Now())
+	err = s.store.CreateReport(ctx, &report)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "You already reported this " + string(target)})
+	}
+	var missing *ReferenceError
+	if errors.As(err, &missing) {
+		return unprocessableReference(c, missing)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to store report: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{"message": "Report submitted successfully", "idReport": report.IDReport})
+}
+
+// GetReports handles GET /reports?status=, the moderation queue: open
+// reports by default, oldest first.
+func (s *Server) GetReports(c echo.Context) error {
+	status := ReportStatus(c.QueryParam("status"))
+	if status == "" {
+		status = ReportOpen
+	}
+	if !slices.Contains(reportStatuses, status) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":   "Unknown status",
+			"allowed": reportStatuses,
+		})
+	}
+	page, ok, err := s.parsePageRequest(c, SortOldest)
+	if !ok {
+		return err
+	}
+
+	reports, err := s.store.ListReports(c.Request().Context(), status, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query reports"})
+	}
+	return c.JSON(http.StatusOK, newPage(reports, page, reportCursor))
+}
+
+// loadReport looks up a report and writes the 404 response if there is none.
+func (s *Server) loadReport(c echo.Context, reportID int) (Report, bool, error) {
+	report, err := s.store.GetReport(c.Request().Context(), reportID)
+	if errors.Is(err, ErrNotFound) {
+		return report, false, c.JSON(http.StatusNotFound, echo.Map{"error": "Report not found"})
+	}
+	if err != nil {
+		return report, false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query report"})
+	}
+	return report, true, nil
+}
+
+// GetReport handles GET /report?id=.
+func (s *Server) GetReport(c echo.Context) error {
+	reportID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid report ID"})
+	}
+	report, ok, err := s.loadReport(c, reportID)
+	if !ok {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// loadAvailableReport looks up a report the caller can work on: an open one
+// or one they claimed. It writes the 404 or 409 response otherwise.
+func (s *Server) loadAvailableReport(c echo.Context, reportID int) (Report, bool, error) {
+	report, ok, err := s.loadReport(c, reportID)
+	if !ok {
+		return report, false, err
+	}
+	if report.Status == ReportResolved {
+		return report, false, c.JSON(http.StatusConflict, echo.Map{"error": "Report is already resolved"})
+	}
+	if report.ClaimedBy != nil && *report.ClaimedBy != currentUser(c).IDUser {
+		return report, false, c.JSON(http.StatusConflict, echo.Map{
+			"error":     "Report is claimed by another moderator",
+			"claimedBy": *report.ClaimedBy,
+		})
+	}
+	return report, true, nil
+}
+
+// ClaimReport handles PUT /claimReport {reportID}, which assigns the report
+// to the caller so other moderators leave it alone.
+func (s *Server) ClaimReport(c echo.Context) error {
+	type ClaimRequest struct {
+		ReportID string `json:"reportID"`
+	}
+
+	var req ClaimRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	reportID, ok := parseID(req.ReportID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Report ID is required"})
+	}
+
+	if _, ok, err := s.loadAvailableReport(c, reportID); !ok {
+		return err
+	}
+	err := s.store.ClaimReport(c.Request().Context(), reportID, currentUser(c).IDUser, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		// Another moderator got there between the two queries
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Report is claimed by another moderator"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to claim report: " + err.Error()})
+	}
+
+	report, ok, err := s.loadReport(c, reportID)
+	if !ok {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// ResolveReport handles PUT /resolveReport {reportID, action, note,
+// suspendDays, hideContent}. The action is carried out before the report is
+// closed; content that is already gone counts as dealt with. suspendDays
+// limits a suspension, which is indefinite without it, and hideContent also
+// hides the author's content from lists while it lasts.
+func (s *Server) ResolveReport(c echo.Context) error {
+	type ResolveRequest struct {
+		ReportID    string           `json:"reportID"`
+		Action      ModerationAction `json:"action"`
+		Note        string           `json:"note"`
+		SuspendDays int              `json:"suspendDays"`
+		HideContent bool             `json:"hideContent"`
+	}
+
+	var req ResolveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	reportID, ok := parseID(req.ReportID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Report ID is required"})
+	}
+	if !slices.Contains(moderationActions, req.Action) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":   "Unknown action",
+			"allowed": moderationActions,
+		})
+	}
+	if len([]rune(req.Note)) > maxReportDetailsLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Note must not be longer than " + strconv.Itoa(maxReportDetailsLength) + " characters"})
+	}
+	if (req.SuspendDays != 0 || req.HideContent) && req.Action != ActionSuspend {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "suspendDays and hideContent only apply to the suspend action"})
+	}
+	if req.SuspendDays < 0 || req.SuspendDays > maxSuspensionDays {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "suspendDays must be between 1 and " + strconv.Itoa(maxSuspensionDays)})
+	}
+
+	report, ok, err := s.loadAvailableReport(c, reportID)
+	if !ok {
+		return err
+	}
+	if ok, err := s.moderate(c, report, req.Action, req.Note, req.SuspendDays, req.HideContent); !ok {
+		return err
+	}
+
+	err = s.store.ResolveReport(c.Request().Context(), reportID, currentUser(c).IDUser, req.Action, req.Note, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Report is claimed by another moderator"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to resolve report: " + err.Error()})
+	}
+
+	report, ok, err = s.loadReport(c, reportID)
+	if !ok {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// moderate carries out action on the content of report and writes the
+// error response if it fails. It returns true if the handler can go on.
+func (s *Server) moderate(c echo.Context, report Report, action ModerationAction, note string, suspendDays int, hideContent bool) (bool, error) {
+	ctx := c.Request().Context()
+	now := time.Now()
+	var err error
+	switch action {
+	case ActionHide:
+		if report.CommentID != nil {
+			err = s.store.HideComment(ctx, *report.CommentID, now)
+		} else if err = s.store.HidePost(ctx, report.PostID, now); err == nil {
+			s.hub.Publish(EventPostDeleted, echo.Map{"idPost": report.PostID}, topicPosts, topicPost(report.PostID))
+		}
+	case ActionDelete:
+		if report.CommentID != nil {
+			err = s.store.DeleteComment(ctx, *report.CommentID, now)
+		} else if err = s.store.DeletePost(ctx, report.PostID, now); err == nil {
+			s.hub.Publish(EventPostDeleted, echo.Map{"idPost": report.PostID}, topicPosts, topicPost(report.PostID))
+		}
+	case ActionSuspend:
+		return s.suspendAuthor(c, report, note, suspendDays, hideContent)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to " + string(action) + " content: " + err.Error()})
+	}
+	return true, nil
+}
+
+// suspendAuthor suspends the author of the reported content for the report
+// reason, followed by the note of the moderator if there is one.
+func (s *Server) suspendAuthor(c echo.Context, report Report, note string, days int, hideContent bool) (bool, error) {
+	ctx := c.Request().Context()
+	author, err := s.store.GetUser(ctx, report.AuthorID)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
+	}
+	if !canSuspendUser(currentUser(c), author) {
+		return false, c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to suspend this user"})
+	}
+
+	reason := string(report.Reason)
+	if note != "" {
+		reason += ": " + note
+	}
+	suspension := newSuspension(currentUser(c).IDUser, reason, days, hideContent, time.Now())
+	if err := s.suspendUser(ctx, author.IDUser, suspension); err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to suspend user: " + err.Error()})
+	}
+	return true, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PostRevision is one version of the text of a post. Revision 1 is the text
+// the post was created with; every edit adds the next number.
+type PostRevision struct {
+	PostID      int    `json:"idPost"`
+	Revision    int    `json:"revision"`
+	EditorID    int    `json:"editorID"`
+	ContentText string `json:"content_text"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// authorizeRevisions looks up the post and writes the 404 or 403 response if
+// the caller may not see its revisions. It returns true if the handler can
+// go on.
+func (s *Server) authorizeRevisions(c echo.Context, postID int) (bool, error) {
+	post, err := s.store.GetPost(c.Request().Context(), postID)
+	if errors.Is(err, ErrNotFound) {
+		return false, c.JSON(http.StatusNotFound, echo.Map{"error": "Post not found"})
+	}
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
+	}
+	if !canViewRevisions(currentUser(c), post.UserID) {
+		return false, c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to see the revisions of this post"})
+	}
+	return true, nil
+}
+
+// GetPostRevisions handles GET /post/revisions?id=, oldest revision first.
+func (s *Server) GetPostRevisions(c echo.Context) error {
+	postID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid post ID"})
+	}
+	page, ok, err := s.parsePageRequest(c, SortOldest)
+	if !ok {
+		return err
+	}
+	if ok, err := s.authorizeRevisions(c, postID); !ok {
+		return err
+	}
+
+	revisions, err := s.store.ListPostRevisions(c.Request().Context(), postID, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query revisions"})
+	}
+	return c.JSON(http.StatusOK, newPage(revisions, page, revisionCursor))
+}
+
+// GetPostDiff handles GET /post/diff?id=&from=&to=&mode=, the changes that
+// turn revision from into revision to. mode is "line" (the default) or
+// "word".
+func (s *Server) GetPostDiff(c echo.Context) error {
+	postID, ok := parseID(c.QueryParam("id"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid post ID"})
+	}
+	from, okFrom := parseID(c.QueryParam("from"))
+	to, okTo := parseID(c.QueryParam("to"))
+	if !okFrom || !okTo {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "from and to must be revision numbers"})
+	}
+	split := splitLines
+	mode := c.QueryParam("mode")
+	switch mode {
+	case "", "line":
+		mode = "line"
+	case "word":
+		split = splitWords
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "mode must be line or word"})
+	}
+
+	if ok, err := s.authorizeRevisions(c, postID); !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var texts [2]string
+	for i, number := range []int{from, to} {
+		revision, err := s.store.GetPostRevision(ctx, postID, number)
+		if errors.Is(err, ErrNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Revision " + strconv.Itoa(number) + " not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query revision"})
+		}
+		texts[i] = revision.ContentText
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"idPost":  postID,
+		"from":    from,
+		"to":      to,
+		"mode":    mode,
+		"changes": diffTokens(split(texts[0]), split(texts[1])),
+	})
+}
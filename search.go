@@ -0,0 +1,149 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SearchType is the kind of row a search result points to.
+type SearchType string
+
+const (
+	SearchPost    SearchType = "post"
+	SearchComment SearchType = "comment"
+	SearchUser    SearchType = "user"
+)
+
+var searchTypes = []SearchType{SearchPost, SearchComment, SearchUser}
+
+// searchAuthors is the column of the user behind each type of result, under
+// the table aliases of the search queries. Users are their own authors, so
+// that users whose suspension hides their content cannot be found either.
+var searchAuthors = map[SearchType]string{
+	SearchPost:    "p.userID",
+	SearchComment: "c.idUser",
+	SearchUser:    "u.idUser",
+}
+
+// SearchResult is one match of GET /search. Snippet is an HTML excerpt of
+// the matching text: the text is escaped and the matched terms are wrapped in
+// <mark> tags, so clients can insert it into a page as is.
+type SearchResult struct {
+	Type SearchType `json:"type"`
+	ID   int        `json:"id"`
+	// PostID is the post a comment belongs to, so clients can link to it.
+	PostID  int     `json:"idPost,omitempty"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+
+	// position is the 1-based rank of the result in the full result list.
+	position int
+}
+
+func searchCursor(r SearchResult) Cursor { return Cursor{Offset: r.position} }
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+
+	// matchStart and matchEnd delimit the matched terms in the excerpts the
+	// databases produce. They are private use characters, so they can be
+	// told apart from markup in the text once it is escaped.
+	matchStart = "\uE000"
+	matchEnd   = "\uE001"
+)
+
+var highlighter = strings.NewReplacer(matchStart, highlightStart, matchEnd, highlightEnd)
+
+// highlightSnippet turns an excerpt delimited with matchStart and matchEnd
+// into the HTML of SearchResult.Snippet.
+func highlightSnippet(excerpt string) string {
+	return highlighter.Replace(html.EscapeString(excerpt))
+}
+
+// Search handles GET /search?q=&type=. Results are ordered by relevance,
+// best first, and paginated like the list endpoints.
+func (s *Server) Search(c echo.Context) error {
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "q is required"})
+	}
+
+	types := searchTypes
+	if value := c.QueryParam("type"); value != "" {
+		t := SearchType(value)
+		if t != SearchPost && t != SearchComment && t != SearchUser {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "type must be post, comment or user"})
+		}
+		types = []SearchType{t}
+	}
+
+	page, ok, err := s.parsePageRequest(c, SortRelevance)
+	if !ok {
+		return err
+	}
+
+	results, err := s.store.Search(c.Request().Context(), q, types, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to search"})
+	}
+	return c.JSON(http.StatusOK, newPage(results, page, searchCursor))
+}
+
+// textMatcher approximates full-text search with case-insensitive substring
+// matching: a text matches when it contains every term, and its score is the
+// number of occurrences. The memory store searches with it, and SQLite
+// highlights with it when it lacks FTS5.
+type textMatcher struct {
+	terms []*regexp.Regexp
+	any   *regexp.Regexp
+}
+
+func newTextMatcher(q string) textMatcher {
+	var m textMatcher
+	var quoted []string
+	for _, term := range strings.Fields(q) {
+		quoted = append(quoted, regexp.QuoteMeta(term))
+		m.terms = append(m.terms, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(term)))
+	}
+	m.any = regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+	return m
+}
+
+func (m textMatcher) match(text string) (string, float64, bool) {
+	for _, term := range m.terms {
+		if !term.MatchString(text) {
+			return "", 0, false
+		}
+	}
+	score := len(m.any.FindAllStringIndex(text, -1))
+	return highlightSnippet(snippetAround(m.any.ReplaceAllString(text, matchStart+"$0"+matchEnd))), float64(score), true
+}
+
+// snippetAround cuts a text with delimited matches down to a few words
+// around the first match, like the snippet function of FTS5.
+func snippetAround(text string) string {
+	const before, length = 4, 16
+	words := strings.Fields(text)
+	first := 0
+	for i, w := range words {
+		if strings.Contains(w, matchStart) {
+			first = i
+			break
+		}
+	}
+	start := max(first-before, 0)
+	end := min(start+length, len(words))
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// Server holds the dependencies shared by the HTTP handlers.
+type Server struct {
+	store    Store
+	cfg      Config
+	timeline timelineStrategy
+	hub      *Hub
+	blobs    BlobStore
+	mailer   Mailer
+}
+
+func NewServer(store Store, cfg Config) *Server {
+	return &Server{
+		store:    store,
+		cfg:      cfg,
+		timeline: newTimelineStrategy(store, cfg.TimelineFanout),
+		hub:      NewHub(cfg.StreamBuffer),
+		blobs:    newLocalBlobStore(cfg.MediaDir, cfg.MediaURL),
+		mailer:   newMailer(cfg),
+	}
+}
+
+// Routes registers every endpoint of the API on e.
+func (s *Server) Routes(e *echo.Echo) {
+	e.Use(s.loadSession)
+
+	e.GET("/posts", s.GetAllPosts)
+	e.GET("/comments", s.GetAllCommentsToPost)
+	e.GET("/comments/thread", s.GetCommentThread)
+	e.GET("/user", s.GetUserByID)
+	e.GET("/users", s.GetAllUsers)
+	e.GET("/posts/user", s.GetPostByUserID)
+	e.GET("/post", s.GetPostById)
+	e.GET("/post/revisions", s.GetPostRevisions, requireAuth)
+	e.GET("/post/diff", s.GetPostDiff, requireAuth)
+	e.GET("/search", s.Search)
+	e.GET("/stream", s.Stream)
+	e.GET("/media/*", s.GetMedia)
+	e.GET("/tags/trending", s.GetTrendingTags)
+	e.GET("/tags/:tag", s.GetTagPosts)
+	e.GET("/timeline", s.GetTimeline, requireAuth)
+	e.GET("/followers", s.GetFollowers)
+	e.GET("/following", s.GetFollowing)
+	e.POST("/follow", s.Follow, requireAuth, s.rejectSuspended)
+	e.DELETE("/unfollow", s.Unfollow, requireAuth)
+	e.GET("/blocks", s.GetBlocks, requireAuth)
+	e.GET("/mutes", s.GetMutes, requireAuth)
+	e.POST("/block", s.Block, requireAuth)
+	e.DELETE("/unblock", s.Unblock, requireAuth)
+	e.POST("/mute", s.Mute, requireAuth)
+	e.DELETE("/unmute", s.Unmute, requireAuth)
+	e.GET("/reactions", s.GetReactions)
+	e.GET("/reactionTypes", s.GetReactionTypes)
+	e.POST("/addReaction", s.AddReaction, requireAuth, s.rejectSuspended)
+	e.DELETE("/removeReaction", s.RemoveReaction, requireAuth)
+	e.GET("/notifications", s.GetNotifications, requireAuth)
+	e.GET("/notifications/unreadCount", s.GetUnreadNotificationCount, requireAuth)
+	e.PUT("/readNotification", s.ReadNotification, requireAuth)
+	e.PUT("/readAllNotifications", s.ReadAllNotifications, requireAuth)
+	e.GET("/conversations", s.GetConversations, requireAuth)
+	e.GET("/conversation", s.GetConversation, requireAuth)
+	e.GET("/messages", s.GetMessages, requireAuth)
+	e.POST("/addConversation", s.AddConversation, requireAuth, s.rejectSuspended)
+	e.POST("/sendMessage", s.SendMessage, requireAuth, s.rejectSuspended)
+	e.PUT("/readConversation", s.ReadConversation, requireAuth)
+	e.POST("/addPost", s.AddPost, requireAuth, s.rejectSuspended)
+	e.POST("/addComment", s.AddComment, requireAuth, s.rejectSuspended)
+	e.DELETE("/deletePost", s.DeletePost, requireAuth)
+	e.PUT("/restorePost", s.RestorePost, requireAuth)
+	e.PUT("/editPost", s.EditPost, requireAuth, s.rejectSuspended)
+	e.PUT("/editComment", s.EditComment, requireAuth, s.rejectSuspended)
+	e.POST("/report", s.AddReport, requireAuth, s.rejectSuspended)
+	e.GET("/reports", s.GetReports, requireAuth, requireModerator)
+	e.GET("/report", s.GetReport, requireAuth, requireModerator)
+	e.PUT("/claimReport", s.ClaimReport, requireAuth, requireModerator)
+	e.PUT("/resolveReport", s.ResolveReport, requireAuth, requireModerator)
+	e.GET("/suspension", s.GetSuspension, requireAuth, requireModerator)
+	e.PUT("/suspendUser", s.SuspendUser, requireAuth, requireModerator)
+	e.PUT("/liftSuspension", s.LiftSuspension, requireAuth, requireModerator)
+	e.POST("/addAttachment", s.AddAttachment, requireAuth, s.rejectSuspended)
+	e.PUT("/editAttachment", s.EditAttachment, requireAuth, s.rejectSuspended)
+	e.DELETE("/deleteAttachment", s.DeleteAttachment, requireAuth)
+	e.DELETE("/deleteComment", s.DeleteComment, requireAuth)
+	e.POST("/login", s.Login)
+	e.POST("/logout", s.Logout, requireAuth)
+	e.POST("/register", s.Register)
+	e.POST("/password/forgot", s.ForgotPassword)
+	e.POST("/password/reset", s.ResetPassword)
+	e.PUT("/userEdit", s.UpdateUser, requireAuth, s.rejectSuspended)
+	e.PUT("/userRole", s.SetUserRole, requireAuth)
+}
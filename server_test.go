@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testServer serves the API from a memory store that the test can fill
+// through fixture.
+type testServer struct {
+	t       *testing.T
+	e       *echo.Echo
+	server  *Server
+	fixture *storeFixture
+}
+
+func newTestServer(t *testing.T) *testServer {
+	store := newMemoryStore()
+	server := NewServer(store, defaultConfig())
+	e := echo.New()
+	server.Routes(e)
+	return &testServer{t: t, e: e, server: server, fixture: newStoreFixture(t, store)}
+}
+
+// login starts a session for u and returns its token.
+func (ts *testServer) login(u User) string {
+	ts.t.Helper()
+	token, _, err := ts.server.createSession(context.Background(), u.IDUser)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return token
+}
+
+// do sends a request with a JSON body, authenticated with token unless it
+// is empty.
+func (ts *testServer) do(token, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	ts.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// get sends an anonymous GET request and returns the status code.
+func (ts *testServer) get(target string) int {
+	return ts.do("", http.MethodGet, target, "").Code
+}
+
+func TestCommentsOfDeletedPost(t *testing.T) {
+	ts := newTestServer(t)
+	f := ts.fixture
+	u := f.user("alice")
+	post := f.post(u.IDUser, "post")
+	root := f.comment(post.IDPost, u.IDUser, nil, "root")
+	f.comment(post.IDPost, u.IDUser, &root.IDComment, "reply")
+
+	targets := []string{
+		"/comments?idPost=" + strconv.Itoa(post.IDPost),
+		"/comments?mode=tree&idPost=" + strconv.Itoa(post.IDPost),
+		"/comments/thread?id=" + strconv.Itoa(root.IDComment),
+	}
+	for _, target := range targets {
+		if code := ts.get(target); code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", target, code, http.StatusOK)
+		}
+	}
+
+	if err := f.s.DeletePost(f.ctx, post.IDPost, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		if code := ts.get(target); code != http.StatusNotFound {
+			t.Errorf("GET %s of a deleted post = %d, want %d", target, code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestModeratorEditKeepsAuthorAsMentioner(t *testing.T) {
+	ts := newTestServer(t)
+	f := ts.fixture
+	alice, bob, carol := f.user("alice"), f.user("bob"), f.user("carol")
+	mod := f.user("mod")
+	if err := f.s.SetUserRole(f.ctx, mod.IDUser, RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	post := f.post(alice.IDUser, "hello")
+	comment := f.comment(post.IDPost, alice.IDUser, nil, "hello again")
+	token := ts.login(mod)
+
+	for _, edit := range []struct{ target, body string }{
+		{"/editPost", `{"postID": "` + strconv.Itoa(post.IDPost) + `", "contentText": "hello @bob"}`},
+		{"/editComment", `{"commentID": "` + strconv.Itoa(comment.IDComment) + `", "contentText": "hello @carol"}`},
+	} {
+		if rec := ts.do(token, http.MethodPut, edit.target, edit.body); rec.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d %s", edit.target, rec.Code, rec.Body)
+		}
+	}
+	for _, u := range []User{bob, carol} {
+		notifications, err := f.s.ListNotifications(f.ctx, u.IDUser, false, firstPage)
+		if err != nil || len(notifications) != 1 || notifications[0].Kind != NotifyMention || notifications[0].ActorID != alice.IDUser {
+			t.Errorf("notifications of %s = %+v, %v, want a mention by alice", u.Username, notifications, err)
+		}
+	}
+}
+
+// stream opens GET /stream?query over HTTP and returns once the
+// subscription exists, which is when the headers arrive.
+func (ts *testServer) stream(query string) *bufio.Scanner {
+	ts.t.Helper()
+	server := httptest.NewServer(ts.e)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream?"+query, nil)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+		server.Close()
+	})
+	if resp.StatusCode != http.StatusOK {
+		ts.t.Fatalf("GET /stream = %d", resp.StatusCode)
+	}
+	return bufio.NewScanner(resp.Body)
+}
+
+// nextPost reads events up to the next one that carries a post.
+func (ts *testServer) nextPost(events *bufio.Scanner) Post {
+	ts.t.Helper()
+	for events.Scan() {
+		data, ok := strings.CutPrefix(events.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var post Post
+		if err := json.Unmarshal([]byte(data), &post); err != nil {
+			ts.t.Fatal(err)
+		}
+		return post
+	}
+	ts.t.Fatalf("stream ended: %v", events.Err())
+	return Post{}
+}
+
+// addPost creates a post by u through the API.
+func (ts *testServer) addPost(u User, text string) {
+	ts.t.Helper()
+	if rec := ts.do(ts.login(u), http.MethodPost, "/addPost", `{"contentText": "`+text+`"}`); rec.Code != http.StatusOK {
+		ts.t.Fatalf("POST /addPost = %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestStreamLeavesOutBlockedAuthors(t *testing.T) {
+	ts := newTestServer(t)
+	f := ts.fixture
+	alice, bob, carol := f.user("alice"), f.user("bob"), f.user("carol")
+	if err := f.s.Restrict(f.ctx, RestrictBlock, alice.IDUser, bob.IDUser, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	events := ts.stream("topic=posts&token=" + ts.login(alice))
+	ts.addPost(bob, "by bob")
+	ts.addPost(carol, "by carol")
+	// Events arrive in order, so carol's post comes after bob's would have
+	if post := ts.nextPost(events); post.UserID != carol.IDUser {
+		t.Errorf("alice received %+v, want carol's post first", post)
+	}
+}
+
+func TestStreamLeavesOutHiddenSuspendedAuthors(t *testing.T) {
+	ts := newTestServer(t)
+	f := ts.fixture
+	bob, carol, mod := f.user("bob"), f.user("carol"), f.user("mod")
+	if err := f.s.SetUserRole(f.ctx, mod.IDUser, RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	post := f.post(bob.IDUser, "by bob")
+	if err := f.s.SuspendUser(f.ctx, bob.IDUser, newSuspension(mod.IDUser, "abuse", 0, true, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	events := ts.stream("topic=posts")
+	body := `{"postID": "` + strconv.Itoa(post.IDPost) + `", "contentText": "edited"}`
+	if rec := ts.do(ts.login(mod), http.MethodPut, "/editPost", body); rec.Code != http.StatusOK {
+		t.Fatalf("PUT /editPost = %d %s", rec.Code, rec.Body)
+	}
+	ts.addPost(carol, "by carol")
+	if got := ts.nextPost(events); got.UserID != carol.IDUser {
+		t.Errorf("received %+v, want carol's post first", got)
+	}
+}
+
+// mailbox is a Mailer that hands the emails to the test.
+type mailbox chan Mail
+
+func (m mailbox) Send(ctx context.Context, mail Mail) error {
+	m <- mail
+	return nil
+}
+
+func TestForgotPasswordMatchesEmailOnly(t *testing.T) {
+	ts := newTestServer(t)
+	f := ts.fixture
+	// Created first, so a lookup by username or email would find it
+	lookalike := User{Username: "alice@example.com", DisplayName: "mallory", Email: "mallory@example.com", Role: RoleUser}
+	if err := f.s.CreateUser(f.ctx, &lookalike, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	alice := f.user("alice")
+	mails := make(mailbox, 1)
+	ts.server.mailer = mails
+
+	if rec := ts.do("", http.MethodPost, "/password/forgot", `{"email": "`+alice.Email+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST /password/forgot = %d %s", rec.Code, rec.Body)
+	}
+	select {
+	case mail := <-mails:
+		if mail.To != alice.Email || !strings.Contains(mail.Body, "account alice.") {
+			t.Errorf("mail = %+v, want one to alice about that account", mail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Store methods when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+)
+
+// ConflictError is returned when a write would violate a UNIQUE constraint.
+// Field names the offending column, e.g. "email".
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Field + " already exists"
+}
+
+// ReferenceError is returned when a write refers to a row that does not
+// exist, which would violate a foreign key. Field names the offending
+// reference, e.g. "postID".
+type ReferenceError struct {
+	Field string
+}
+
+func (e *ReferenceError) Error() string {
+	return e.Field + " does not exist"
+}
+
+// Store is the persistence layer used by the HTTP handlers. Implementations
+// must be safe for concurrent use.
+//
+// List methods return at most page.Limit items in page.Sort order, starting
+// after page.After. Posts and comments are ordered by (created_at, id),
+// users by id.
+type Store interface {
+	UserStore
+	SessionStore
+	PasswordResetStore
+	PostStore
+	CommentStore
+	SearchStore
+	FollowStore
+	TimelineStore
+	ReactionStore
+	NotificationStore
+	TagStore
+	AttachmentStore
+	MessageStore
+	RestrictionStore
+	ReportStore
+
+	Close() error
+}
+
+type UserStore interface {
+	// CreateUser inserts u and sets u.IDUser. A duplicate username, display
+	// name or email is reported as *ConflictError.
+	CreateUser(ctx context.Context, u *User, passwordHash string) error
+	GetUser(ctx context.Context, id int) (User, error)
+	// GetUserByLogin finds a user by username or email and also returns the
+	// stored password hash.
+	GetUserByLogin(ctx context.Context, login string) (User, string, error)
+	// GetUserByEmail finds a user by email only.
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	ListUsers(ctx context.Context, page PageRequest) ([]User, error)
+	// UpdateUser changes the username, display name and email of u.IDUser.
+	UpdateUser(ctx context.Context, u User) error
+	SetUserRole(ctx context.Context, id int, role Role) error
+	SetUserPassword(ctx context.Context, id int, passwordHash string) error
+	// SuspendUser records a suspension of the user, replacing any earlier
+	// one.
+	SuspendUser(ctx context.Context, id int, suspension Suspension) error
+	// GetSuspension returns the recorded suspension of the user, which may
+	// have ended. A user who was never suspended is reported as ErrNotFound.
+	GetSuspension(ctx context.Context, id int) (Suspension, error)
+	// LiftSuspension clears the suspension of the user, or returns
+	// ErrNotFound if there is none.
+	LiftSuspension(ctx context.Context, id int) error
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error
+	// GetSessionUser returns the owner of the session and when it expires.
+	GetSessionUser(ctx context.Context, tokenHash string) (User, time.Time, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	// DeleteUserSessions ends every session of the user.
+	DeleteUserSessions(ctx context.Context, userID int) error
+}
+
+// PasswordResetStore keeps the tokens of password reset emails. Like
+// sessions, only the hash of a token is stored.
+type PasswordResetStore interface {
+	CreatePasswordReset(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error
+	// ResetPassword uses up the token if it was neither used nor expired by
+	// at: it sets the password of its user, ends their sessions and voids
+	// their other tokens. It returns the user, or ErrNotFound for a token
+	// that cannot be used.
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string, at time.Time) (int, error)
+}
+
+type PostStore interface {
+	// CreatePost inserts p and sets p.IDPost. Its text is recorded as the
+	// first revision. An unknown author is reported as *ReferenceError.
+	CreatePost(ctx context.Context, p *Post) error
+	// GetPost, the List methods and UpdatePostContent treat soft-deleted
+	// posts as missing.
+	GetPost(ctx context.Context, id int) (Post, error)
+	// ListPosts and ListPostsByUser leave out the posts of the users
+	// viewerID blocked or muted, and of users whose suspension hides their
+	// content; a viewerID of 0 is an anonymous viewer.
+	ListPosts(ctx context.Context, viewerID int, page PageRequest) ([]Post, error)
+	ListPostsByUser(ctx context.Context, userID, viewerID int, page PageRequest) ([]Post, error)
+	// UpdatePostContent changes the text of the post and records it as a new
+	// revision by editorID.
+	UpdatePostContent(ctx context.Context, id int, content string, editorID int, editedAt time.Time) error
+	// DeletePost soft-deletes the post; it can be restored until purged.
+	DeletePost(ctx context.Context, id int, deletedAt time.Time) error
+	// GetDeletedPost returns a soft-deleted post.
+	GetDeletedPost(ctx context.Context, id int) (Post, error)
+	// RestorePost undoes the deletion or hiding of a post.
+	RestorePost(ctx context.Context, id int) error
+	// HidePost soft-deletes the post on behalf of a moderator and exempts it
+	// from purging. It returns ErrNotFound if the post is already hidden.
+	HidePost(ctx context.Context, id int, hiddenAt time.Time) error
+	// PurgeDeletedPosts permanently deletes the posts soft-deleted before
+	// the given time, with their comments and attachments, but not the
+	// hidden ones. It returns how many there were and the blob keys of the
+	// attachments, which the caller removes from the BlobStore.
+	PurgeDeletedPosts(ctx context.Context, deletedBefore time.Time) (int, []string, error)
+	// ListPostRevisions lists the revisions of a post, ordered by number.
+	ListPostRevisions(ctx context.Context, postID int, page PageRequest) ([]PostRevision, error)
+	GetPostRevision(ctx context.Context, postID, revision int) (PostRevision, error)
+}
+
+type CommentStore interface {
+	// CreateComment inserts c and sets c.IDComment. An unknown post, author
+	// or parent comment is reported as *ReferenceError.
+	CreateComment(ctx context.Context, c *Comment) error
+	GetComment(ctx context.Context, id int) (Comment, error)
+	// UpdateCommentContent changes the text of a comment that is not deleted.
+	UpdateCommentContent(ctx context.Context, id int, content string, editedAt time.Time) error
+	// DeleteComment removes a comment that is not deleted yet. A comment
+	// with replies is turned into a tombstone instead, and tombstones left
+	// without replies are removed along with it.
+	DeleteComment(ctx context.Context, id int, deletedAt time.Time) error
+	// HideComment turns a comment into a tombstone on behalf of a moderator,
+	// which stays even without replies. It returns ErrNotFound if the
+	// comment is already hidden.
+	HideComment(ctx context.Context, id int, hiddenAt time.Time) error
+	// The List methods leave out the comments of the users viewerID blocked
+	// or muted and of suspended users, like ListPosts.
+	//
+	// ListCommentsByPost lists every comment of the post, replies included.
+	ListCommentsByPost(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error)
+	// ListRootComments lists the top-level comments of the post.
+	ListRootComments(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error)
+	// ListReplies returns every reply below the given comments, at any depth,
+	// ordered by (created_at, id).
+	ListReplies(ctx context.Context, rootIDs []int, viewerID int) ([]Comment, error)
+}
+
+type SearchStore interface {
+	// Search returns the posts, comments and users of the given types that
+	// match every term of q, best match first. Pages are addressed by offset.
+	Search(ctx context.Context, q string, types []SearchType, page PageRequest) ([]SearchResult, error)
+}
+
+type FollowStore interface {
+	// Follow makes followerID follow followeeID; following twice is not an
+	// error. An unknown followee is reported as *ReferenceError.
+	Follow(ctx context.Context, followerID, followeeID int, at time.Time) error
+	// Unfollow returns ErrNotFound if followerID did not follow followeeID.
+	Unfollow(ctx context.Context, followerID, followeeID int) error
+	// ListFollowers and ListFollowing are ordered by when the follow began.
+	ListFollowers(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error)
+	ListFollowing(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error)
+	FollowCounts(ctx context.Context, userID int) (followers, following int, err error)
+}
+
+// TimelineStore backs both timeline strategies, see timeline.go. Timelines
+// are ordered like post lists and never contain soft-deleted posts, nor the
+// posts of users whose suspension hides their content or whom userID blocked
+// or muted.
+type TimelineStore interface {
+	// ListFolloweePosts builds a timeline on read from the posts of the
+	// accounts userID follows.
+	ListFolloweePosts(ctx context.Context, userID int, page PageRequest) ([]Post, error)
+
+	// ListTimeline reads the timeline materialized by the methods below.
+	ListTimeline(ctx context.Context, userID int, page PageRequest) ([]Post, error)
+	// FanOutPost adds a post to the timelines of its author's followers.
+	FanOutPost(ctx context.Context, postID int) error
+	// AddToTimeline adds the posts of followeeID to the timeline of
+	// followerID, RemoveFromTimeline takes them out again.
+	AddToTimeline(ctx context.Context, followerID, followeeID int) error
+	RemoveFromTimeline(ctx context.Context, followerID, followeeID int) error
+	// RebuildTimelines materializes every timeline from scratch.
+	RebuildTimelines(ctx context.Context) error
+}
+
+// openStore returns the Store selected by cfg.Database: "memory" keeps
+// everything in process memory, a postgres:// or postgresql:// URL connects
+// to PostgreSQL, and anything else is the path of a SQLite file. SQL stores
+// are migrated to the latest schema when cfg.AutoMigrate is set.
+func openStore(cfg Config) (Store, error) {
+	if cfg.Database == "memory" {
+		return newMemoryStore(), nil
+	}
+
+	store, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AutoMigrate {
+		if err := migrateUp(store); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// openDatabase connects to the SQL database named by cfg.Database.
+func openDatabase(cfg Config) (*sqlStore, error) {
+	if strings.HasPrefix(cfg.Database, "postgres://") || strings.HasPrefix(cfg.Database, "postgresql://") {
+		return openPostgresStore(cfg.Database)
+	}
+	return openSQLiteStore(cfg.Database)
+}
+
+// formatTime renders t the way created_at and similar columns store it.
+// Always using UTC keeps the text sortable in SQLite.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ReactionStore keeps the reactions of users to posts and comments. A user
+// can react to a target once per reaction type.
+type ReactionStore interface {
+	// AddReaction records a reaction; adding it twice is not an error. An
+	// unknown target or user is reported as *ReferenceError.
+	AddReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string, at time.Time) error
+	RemoveReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string) error
+	// ListReactions lists the reactions to a target, of every type if
+	// reaction is empty.
+	ListReactions(ctx context.Context, target ReactionTarget, targetID int, reaction string, page PageRequest) ([]Reaction, error)
+	// ReactionSummaries counts the reactions to each of the targets by type
+	// and reports which types viewerID used. Targets without reactions are
+	// left out of the map.
+	ReactionSummaries(ctx context.Context, target ReactionTarget, targetIDs []int, viewerID int) (map[int]ReactionSummary, error)
+}
+
+type NotificationStore interface {
+	// Notify records an event. It is merged into the unread notification of
+	// the same user, kind and target if there is one, counting each actor
+	// once.
+	Notify(ctx context.Context, e NotificationEvent) error
+	// ListNotifications is ordered by (updated_at, id).
+	ListNotifications(ctx context.Context, userID int, unreadOnly bool, page PageRequest) ([]Notification, error)
+	UnreadNotificationCount(ctx context.Context, userID int) (int, error)
+	// MarkNotificationRead reports notifications of other users as missing.
+	// Marking a read notification again keeps the first read time.
+	MarkNotificationRead(ctx context.Context, userID, id int, at time.Time) error
+	// MarkAllNotificationsRead returns how many notifications were unread.
+	MarkAllNotificationsRead(ctx context.Context, userID int, at time.Time) (int, error)
+}
+
+// TagStore keeps the mentions and hashtags of posts and comments.
+type TagStore interface {
+	// SetContentLinks replaces the mentions and hashtags of a post or
+	// comment. Usernames that match no user are ignored. It returns the
+	// users that were not mentioned by the content before.
+	SetContentLinks(ctx context.Context, ref ContentRef, links ContentLinks) ([]int, error)
+	// ListPostsByTag lists the posts whose own text has the hashtag, leaving
+	// out those hidden by a suspension.
+	ListPostsByTag(ctx context.Context, tag string, page PageRequest) ([]Post, error)
+	// TrendingTags ranks the hashtags of the posts and comments created
+	// since the given time by number of uses.
+	TrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error)
+}
+
+// AttachmentStore keeps the attachment rows of posts; the files themselves
+// are in a BlobStore.
+type AttachmentStore interface {
+	// CreateAttachment inserts a and sets a.IDAttachment. An unknown post is
+	// reported as *ReferenceError.
+	CreateAttachment(ctx context.Context, a *Attachment) error
+	GetAttachment(ctx context.Context, id int) (Attachment, error)
+	// ListAttachments returns the attachments of each of the posts, ordered
+	// by ID. Posts without attachments are left out of the map.
+	ListAttachments(ctx context.Context, postIDs []int) (map[int][]Attachment, error)
+	UpdateAttachmentAltText(ctx context.Context, id int, altText string) error
+	DeleteAttachment(ctx context.Context, id int) error
+}
+
+// MessageStore keeps conversations and their messages. Methods taking a
+// viewer or member ID report conversations the user is not a member of as
+// missing.
+type MessageStore interface {
+	// CreateConversation inserts c with the given members and sets
+	// c.IDConversation. If c is direct and the two members already have a
+	// direct conversation, its ID is set instead. An unknown member is
+	// reported as *ReferenceError.
+	CreateConversation(ctx context.Context, c *Conversation, memberIDs []int) error
+	// GetConversation returns a conversation of viewerID with its members,
+	// last message and unread count.
+	GetConversation(ctx context.Context, id, viewerID int) (Conversation, error)
+	// ListConversations lists the conversations of userID like
+	// GetConversation, ordered by (updated_at, id).
+	ListConversations(ctx context.Context, userID int, page PageRequest) ([]Conversation, error)
+	// CreateMessage inserts m, sets m.IDMessage and marks it read for the
+	// sender.
+	CreateMessage(ctx context.Context, m *Message) error
+	ListMessages(ctx context.Context, conversationID int, page PageRequest) ([]Message, error)
+	// MarkConversationRead moves the read receipt of userID forward to
+	// messageID, or to the last message if it is 0, and returns the receipt
+	// afterwards. A message of another conversation is reported as
+	// ErrNotFound.
+	MarkConversationRead(ctx context.Context, conversationID, userID, messageID int, at time.Time) (ReadReceipt, error)
+}
+
+// RestrictionStore keeps the blocks and mutes between users.
+type RestrictionStore interface {
+	// Restrict makes userID block or mute targetID; doing it twice is not an
+	// error. Blocking also removes the follows between the two users. An
+	// unknown target is reported as *ReferenceError.
+	Restrict(ctx context.Context, kind Restriction, userID, targetID int, at time.Time) error
+	// Unrestrict returns ErrNotFound if userID did not restrict targetID.
+	Unrestrict(ctx context.Context, kind Restriction, userID, targetID int) error
+	// ListRestricted lists the users userID restricted, ordered by when.
+	ListRestricted(ctx context.Context, kind Restriction, userID int, page PageRequest) ([]RestrictedUser, error)
+	// Restricts reports whether userID restricted targetID with kind, or
+	// with either kind if it is empty.
+	Restricts(ctx context.Context, userID, targetID int, kind Restriction) (bool, error)
+}
+
+// ReportStore keeps the reports of posts and comments and the decisions of
+// the moderators about them.
+type ReportStore interface {
+	// CreateReport inserts r and sets r.IDReport. A reporter with an
+	// unresolved report of the same content is reported as *ConflictError.
+	CreateReport(ctx context.Context, r *Report) error
+	GetReport(ctx context.Context, id int) (Report, error)
+	// ListReports lists the reports with the given status, ordered by
+	// (created_at, id).
+	ListReports(ctx context.Context, status ReportStatus, page PageRequest) ([]Report, error)
+	// ClaimReport assigns the report to moderatorID. It returns ErrNotFound
+	// unless the report is open or already claimed by moderatorID.
+	ClaimReport(ctx context.Context, id, moderatorID int, at time.Time) error
+	// ResolveReport records the decision of moderatorID, claiming the report
+	// if needed. It returns ErrNotFound under the same conditions as
+	// ClaimReport.
+	ResolveReport(ctx context.Context, id, moderatorID int, action ModerationAction, note string, at time.Time) error
+}
@@ -0,0 +1,1614 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memorySession struct {
+	userID    int
+	expiresAt time.Time
+}
+
+type memoryPasswordReset struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+type memoryUser struct {
+	User
+	passwordHash string
+	suspension   *Suspension
+}
+
+// memoryLink is a mention of userID or a use of tag by a post or comment.
+type memoryLink struct {
+	ContentRef
+	userID    int
+	tag       string
+	createdAt string
+}
+
+type memoryNotification struct {
+	Notification
+	userID int
+	actors map[int]bool
+}
+
+// memoryConversation holds the members of a conversation by user ID.
+type memoryConversation struct {
+	Conversation
+	directKey string
+	members   map[int]*ConversationMember
+}
+
+// memoryRestriction identifies a block or mute of targetID by userID.
+type memoryRestriction struct {
+	userID, targetID int
+	kind             Restriction
+}
+
+type memoryReaction struct {
+	Reaction
+	target   ReactionTarget
+	targetID int
+}
+
+// memoryStore is a Store that keeps everything in maps. It is meant for
+// tests and local experiments; nothing survives a restart.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	users    map[int]*memoryUser
+	sessions map[string]memorySession
+	posts    map[int]*Post
+	comments map[int]*Comment
+	// revisions holds the revisions of every post in order.
+	revisions map[int][]PostRevision
+	// follows maps (follower, followee) to when the follow began.
+	follows map[[2]int]string
+	// timelines holds the post IDs of every materialized timeline.
+	timelines map[int]map[int]bool
+	reactions map[int]*memoryReaction
+	// notifications keeps the distinct actors of every notification.
+	notifications map[int]*memoryNotification
+	links         []memoryLink
+	attachments   map[int]*Attachment
+	conversations map[int]*memoryConversation
+	messages      map[int]*Message
+	// restrictions maps each block and mute to when it was made.
+	restrictions map[memoryRestriction]string
+	reports      map[int]*Report
+	// passwordResets maps the hash of each reset token to its state.
+	passwordResets map[string]*memoryPasswordReset
+
+	// Last assigned IDs, mirroring SQLite AUTOINCREMENT
+	lastUserID         int
+	lastPostID         int
+	lastCommentID      int
+	lastReactionID     int
+	lastNotificationID int
+	lastAttachmentID   int
+	lastConversationID int
+	lastMessageID      int
+	lastReportID       int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:     map[int]*memoryUser{},
+		sessions:  map[string]memorySession{},
+		posts:     map[int]*Post{},
+		comments:  map[int]*Comment{},
+		revisions: map[int][]PostRevision{},
+		follows:   map[[2]int]string{},
+		timelines: map[int]map[int]bool{},
+		reactions: map[int]*memoryReaction{},
+
+		notifications: map[int]*memoryNotification{},
+		attachments:   map[int]*Attachment{},
+		conversations: map[int]*memoryConversation{},
+		messages:      map[int]*Message{},
+		restrictions:  map[memoryRestriction]string{},
+		reports:       map[int]*Report{},
+
+		passwordResets: map[string]*memoryPasswordReset{},
+	}
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// sortedKeys returns the keys of m in ascending order, which is the insertion
+// order since IDs only grow.
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// cursorLess orders two positions by creation time, then ID.
+func cursorLess(a, b Cursor) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.ID < b.ID
+}
+
+// pageOf sorts items in page order and returns at most page.Limit of them
+// that come after page.After, mirroring the keyset queries of sqlStore.
+func pageOf[T any](items []T, page PageRequest, cursorOf func(T) Cursor) []T {
+	before := func(a, b Cursor) bool {
+		if page.Sort == SortNewest {
+			return cursorLess(b, a)
+		}
+		return cursorLess(a, b)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return before(cursorOf(items[i]), cursorOf(items[j]))
+	})
+
+	var result []T
+	for _, item := range items {
+		if len(result) == page.Limit {
+			break
+		}
+		if page.After != nil && !before(*page.After, cursorOf(item)) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// checkUnique reports a *ConflictError if another user than u.IDUser already
+// uses one of the unique columns of u.
+func (s *memoryStore) checkUnique(u *User) error {
+	for _, other := range s.users {
+		if other.IDUser == u.IDUser {
+			continue
+		}
+		switch {
+		case other.Username == u.Username:
+			return &ConflictError{Field: "username"}
+		case other.DisplayName == u.DisplayName:
+			return &ConflictError{Field: "displayName"}
+		case other.Email == u.Email:
+			return &ConflictError{Field: "email"}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateUser(ctx context.Context, u *User, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(u); err != nil {
+		return err
+	}
+	s.lastUserID++
+	u.IDUser = s.lastUserID
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	s.users[u.IDUser] = &memoryUser{User: *u, passwordHash: passwordHash}
+	return nil
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u.User, nil
+}
+
+func (s *memoryStore) GetUserByLogin(ctx context.Context, login string) (User, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, id := range sortedKeys(s.users) {
+		u := s.users[id]
+		if u.Username == login || u.Email == login {
+			return u.User, u.passwordHash, nil
+		}
+	}
+	return User{}, "", ErrNotFound
+}
+
+func (s *memoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return u.User, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryStore) ListUsers(ctx context.Context, page PageRequest) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []User
+	for _, id := range sortedKeys(s.users) {
+		users = append(users, s.users[id].User)
+	}
+	return pageOf(users, page, userCursor), nil
+}
+
+func (s *memoryStore) UpdateUser(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[u.IDUser]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.checkUnique(&u); err != nil {
+		return err
+	}
+	stored.Username = u.Username
+	stored.DisplayName = u.DisplayName
+	stored.Email = u.Email
+	return nil
+}
+
+func (s *memoryStore) SetUserRole(ctx context.Context, id int, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+func (s *memoryStore) SetUserPassword(ctx context.Context, id int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.passwordHash = passwordHash
+	return nil
+}
+
+func (s *memoryStore) SuspendUser(ctx context.Context, id int, suspension Suspension) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.suspension = &suspension
+	return nil
+}
+
+func (s *memoryStore) GetSuspension(ctx context.Context, id int) (Suspension, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok || u.suspension == nil {
+		return Suspension{}, ErrNotFound
+	}
+	return *u.suspension, nil
+}
+
+func (s *memoryStore) LiftSuspension(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || u.suspension == nil {
+		return ErrNotFound
+	}
+	u.suspension = nil
+	return nil
+}
+
+func (s *memoryStore) CreateSession(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[tokenHash] = memorySession{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryStore) GetSessionUser(ctx context.Context, tokenHash string) (User, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[tokenHash]
+	if !ok {
+		return User{}, time.Time{}, ErrNotFound
+	}
+	u, ok := s.users[session.userID]
+	if !ok {
+		return User{}, time.Time{}, ErrNotFound
+	}
+	return u.User, session.expiresAt, nil
+}
+
+func (s *memoryStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *memoryStore) DeleteUserSessions(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for tokenHash, session := range s.sessions {
+		if session.userID == userID {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreatePasswordReset(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.passwordResets[tokenHash] = &memoryPasswordReset{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryStore) ResetPassword(ctx context.Context, tokenHash, passwordHash string, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset, ok := s.passwordResets[tokenHash]
+	if !ok || reset.used || !reset.expiresAt.After(at) {
+		return 0, ErrNotFound
+	}
+	u, ok := s.users[reset.userID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	for _, r := range s.passwordResets {
+		if r.userID == reset.userID {
+			r.used = true
+		}
+	}
+	u.passwordHash = passwordHash
+	for hash, session := range s.sessions {
+		if session.userID == reset.userID {
+			delete(s.sessions, hash)
+		}
+	}
+	return reset.userID, nil
+}
+
+func (s *memoryStore) CreatePost(ctx context.Context, p *Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[p.UserID]; !ok {
+		return &ReferenceError{Field: "userID"}
+	}
+	s.lastPostID++
+	p.IDPost = s.lastPostID
+	stored := *p
+	s.posts[p.IDPost] = &stored
+	s.revisions[p.IDPost] = []PostRevision{
+		{PostID: p.IDPost, Revision: 1, EditorID: p.UserID, ContentText: p.ContentText, CreatedAt: p.CreatedAt},
+	}
+	return nil
+}
+
+func (s *memoryStore) GetPost(ctx context.Context, id int) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return Post{}, ErrNotFound
+	}
+	return *p, nil
+}
+
+// filterPosts returns the page of posts matching keep, skipping deleted ones.
+func (s *memoryStore) filterPosts(page PageRequest, keep func(*Post) bool) []Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var posts []Post
+	for _, id := range sortedKeys(s.posts) {
+		if p := s.posts[id]; p.DeletedAt == nil && keep(p) {
+			posts = append(posts, *p)
+		}
+	}
+	return pageOf(posts, page, postCursor)
+}
+
+func (s *memoryStore) ListPosts(ctx context.Context, viewerID int, page PageRequest) ([]Post, error) {
+	return s.filterPosts(page, func(p *Post) bool { return !s.hides(viewerID, p.UserID) }), nil
+}
+
+func (s *memoryStore) ListPostsByUser(ctx context.Context, userID, viewerID int, page PageRequest) ([]Post, error) {
+	return s.filterPosts(page, func(p *Post) bool { return p.UserID == userID && !s.hides(viewerID, p.UserID) }), nil
+}
+
+func (s *memoryStore) UpdatePostContent(ctx context.Context, id int, content string, editorID int, editedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return ErrNotFound
+	}
+	p.ContentText = content
+	s.revisions[id] = append(s.revisions[id], PostRevision{
+		PostID:      id,
+		Revision:    len(s.revisions[id]) + 1,
+		EditorID:    editorID,
+		ContentText: content,
+		CreatedAt:   formatTime(editedAt),
+	})
+	return nil
+}
+
+func (s *memoryStore) ListPostRevisions(ctx context.Context, postID int, page PageRequest) ([]PostRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := append([]PostRevision(nil), s.revisions[postID]...)
+	return pageOf(revisions, page, revisionCursor), nil
+}
+
+func (s *memoryStore) GetPostRevision(ctx context.Context, postID, revision int) (PostRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := s.revisions[postID]
+	if revision < 1 || revision > len(revisions) {
+		return PostRevision{}, ErrNotFound
+	}
+	return revisions[revision-1], nil
+}
+
+func (s *memoryStore) DeletePost(ctx context.Context, id int, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return ErrNotFound
+	}
+	deleted := formatTime(deletedAt)
+	p.DeletedAt = &deleted
+	return nil
+}
+
+func (s *memoryStore) GetDeletedPost(ctx context.Context, id int) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt == nil {
+		return Post{}, ErrNotFound
+	}
+	return *p, nil
+}
+
+func (s *memoryStore) RestorePost(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt == nil {
+		return ErrNotFound
+	}
+	p.DeletedAt = nil
+	p.HiddenAt = nil
+	return nil
+}
+
+func (s *memoryStore) HidePost(ctx context.Context, id int, hiddenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok || p.HiddenAt != nil {
+		return ErrNotFound
+	}
+	hidden := formatTime(hiddenAt)
+	if p.DeletedAt == nil {
+		p.DeletedAt = &hidden
+	}
+	p.HiddenAt = &hidden
+	return nil
+}
+
+func (s *memoryStore) PurgeDeletedPosts(ctx context.Context, deletedBefore time.Time) (int, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := formatTime(deletedBefore)
+	purged := 0
+	var keys []string
+	for id, p := range s.posts {
+		if p.DeletedAt == nil || *p.DeletedAt >= cutoff || p.HiddenAt != nil {
+			continue
+		}
+		delete(s.posts, id)
+		delete(s.revisions, id)
+		s.deleteReactions(ReactToPost, id)
+		s.deleteNotifications(id, 0)
+		s.deleteLinks(ContentRef{PostID: id})
+		for attachmentID, a := range s.attachments {
+			if a.PostID == id {
+				delete(s.attachments, attachmentID)
+				keys = append(keys, a.BlobKey, a.ThumbnailKey)
+			}
+		}
+		for commentID, c := range s.comments {
+			if c.IDPost == id {
+				delete(s.comments, commentID)
+				s.deleteReactions(ReactToComment, commentID)
+				s.deleteNotifications(0, commentID)
+			}
+		}
+		purged++
+	}
+	return purged, keys, nil
+}
+
+func (s *memoryStore) CreateComment(ctx context.Context, c *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.posts[c.IDPost]; !ok {
+		return &ReferenceError{Field: "postID"}
+	}
+	if _, ok := s.users[c.IDUser]; !ok {
+		return &ReferenceError{Field: "userID"}
+	}
+	if c.ParentID != nil {
+		if _, ok := s.comments[*c.ParentID]; !ok {
+			return &ReferenceError{Field: "parentCommentID"}
+		}
+	}
+	s.lastCommentID++
+	c.IDComment = s.lastCommentID
+	stored := *c
+	if c.ParentID != nil {
+		parentID := *c.ParentID
+		stored.ParentID = &parentID
+	}
+	s.comments[c.IDComment] = &stored
+	return nil
+}
+
+func (s *memoryStore) GetComment(ctx context.Context, id int) (Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return Comment{}, ErrNotFound
+	}
+	return *c, nil
+}
+
+func (s *memoryStore) UpdateCommentContent(ctx context.Context, id int, content string, editedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok || c.Deleted {
+		return ErrNotFound
+	}
+	edited := formatTime(editedAt)
+	c.ContentText = content
+	c.EditedAt = &edited
+	return nil
+}
+
+func (s *memoryStore) hasReplies(id int) bool {
+	for _, c := range s.comments {
+		if c.ParentID != nil && *c.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) DeleteComment(ctx context.Context, id int, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok || c.Deleted {
+		return ErrNotFound
+	}
+	if s.hasReplies(id) {
+		c.Deleted = true
+		c.ContentText = deletedCommentText
+		return nil
+	}
+
+	delete(s.comments, id)
+	s.deleteReactions(ReactToComment, id)
+	s.deleteNotifications(0, id)
+	s.deleteLinks(ContentRef{CommentID: id})
+	for parentID := c.ParentID; parentID != nil; {
+		parent, ok := s.comments[*parentID]
+		if !ok || !parent.Deleted || parent.Hidden || s.hasReplies(parent.IDComment) {
+			break
+		}
+		delete(s.comments, parent.IDComment)
+		s.deleteReactions(ReactToComment, parent.IDComment)
+		s.deleteNotifications(0, parent.IDComment)
+		s.deleteLinks(ContentRef{CommentID: parent.IDComment})
+		parentID = parent.ParentID
+	}
+	return nil
+}
+
+func (s *memoryStore) HideComment(ctx context.Context, id int, hiddenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok || c.Hidden {
+		return ErrNotFound
+	}
+	c.Deleted = true
+	c.Hidden = true
+	c.ContentText = hiddenCommentText
+	return nil
+}
+
+// filterComments returns the page of comments matching keep.
+func (s *memoryStore) filterComments(page PageRequest, keep func(*Comment) bool) []Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var comments []Comment
+	for _, id := range sortedKeys(s.comments) {
+		if c := s.comments[id]; keep(c) {
+			comments = append(comments, *c)
+		}
+	}
+	return pageOf(comments, page, commentCursor)
+}
+
+func (s *memoryStore) ListCommentsByPost(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error) {
+	return s.filterComments(page, func(c *Comment) bool { return c.IDPost == postID && !s.hides(viewerID, c.IDUser) }), nil
+}
+
+func (s *memoryStore) ListRootComments(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error) {
+	return s.filterComments(page, func(c *Comment) bool {
+		return c.IDPost == postID && c.ParentID == nil && !s.hides(viewerID, c.IDUser)
+	}), nil
+}
+
+func (s *memoryStore) ListReplies(ctx context.Context, rootIDs []int, viewerID int) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inThread := map[int]bool{}
+	for _, id := range rootIDs {
+		inThread[id] = true
+	}
+	// IDs only grow, so a reply is always visited after its parent.
+	var replies []Comment
+	for _, id := range sortedKeys(s.comments) {
+		c := s.comments[id]
+		if c.ParentID != nil && inThread[*c.ParentID] {
+			inThread[id] = true
+			if !s.hides(viewerID, c.IDUser) {
+				replies = append(replies, *c)
+			}
+		}
+	}
+	return pageOf(replies, PageRequest{Limit: len(replies), Sort: SortOldest}, commentCursor), nil
+}
+
+func (s *memoryStore) Search(ctx context.Context, q string, types []SearchType, page PageRequest) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := newTextMatcher(q)
+	var results []SearchResult
+	for _, t := range types {
+		switch t {
+		case SearchPost:
+			for _, id := range sortedKeys(s.posts) {
+				if s.posts[id].DeletedAt != nil || s.hides(0, s.posts[id].UserID) {
+					continue
+				}
+				if snippet, score, ok := m.match(s.posts[id].ContentText); ok {
+					results = append(results, SearchResult{Type: t, ID: id, PostID: id, Snippet: snippet, Score: score})
+				}
+			}
+		case SearchComment:
+			for _, id := range sortedKeys(s.comments) {
+				c := s.comments[id]
+				if c.Deleted || s.posts[c.IDPost].DeletedAt != nil || s.hides(0, c.IDUser) {
+					continue
+				}
+				if snippet, score, ok := m.match(c.ContentText); ok {
+					results = append(results, SearchResult{Type: t, ID: id, PostID: c.IDPost, Snippet: snippet, Score: score})
+				}
+			}
+		case SearchUser:
+			for _, id := range sortedKeys(s.users) {
+				u := s.users[id]
+				if s.hides(0, id) {
+					continue
+				}
+				if snippet, score, ok := m.match(u.Username + " " + u.DisplayName); ok {
+					results = append(results, SearchResult{Type: t, ID: id, Snippet: snippet, Score: score})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	offset := min(page.offset(), len(results))
+	results = results[offset:min(offset+page.Limit, len(results))]
+	for i := range results {
+		results[i].position = offset + i + 1
+	}
+	return results, nil
+}
+
+func (s *memoryStore) Follow(ctx context.Context, followerID, followeeID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[followeeID]; !ok {
+		return &ReferenceError{Field: "userID"}
+	}
+	key := [2]int{followerID, followeeID}
+	if _, ok := s.follows[key]; !ok {
+		s.follows[key] = formatTime(at)
+	}
+	return nil
+}
+
+func (s *memoryStore) Unfollow(ctx context.Context, followerID, followeeID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int{followerID, followeeID}
+	if _, ok := s.follows[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.follows, key)
+	return nil
+}
+
+// listFollows lists the users on the other side of userID's follows; side is
+// the index of userID in the follows keys.
+func (s *memoryStore) listFollows(userID, side int, page PageRequest) []FollowUser {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []FollowUser
+	for key, followedAt := range s.follows {
+		if key[side] == userID {
+			users = append(users, FollowUser{User: s.users[key[1-side]].User, FollowedAt: followedAt})
+		}
+	}
+	return pageOf(users, page, followCursor)
+}
+
+func (s *memoryStore) ListFollowers(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error) {
+	return s.listFollows(userID, 1, page), nil
+}
+
+func (s *memoryStore) ListFollowing(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error) {
+	return s.listFollows(userID, 0, page), nil
+}
+
+func (s *memoryStore) FollowCounts(ctx context.Context, userID int) (int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var followers, following int
+	for key := range s.follows {
+		if key[1] == userID {
+			followers++
+		}
+		if key[0] == userID {
+			following++
+		}
+	}
+	return followers, following, nil
+}
+
+func (s *memoryStore) ListFolloweePosts(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	return s.filterPosts(page, func(p *Post) bool {
+		_, ok := s.follows[[2]int{userID, p.UserID}]
+		return ok && !s.hides(userID, p.UserID)
+	}), nil
+}
+
+func (s *memoryStore) ListTimeline(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	return s.filterPosts(page, func(p *Post) bool { return s.timelines[userID][p.IDPost] && !s.hides(userID, p.UserID) }), nil
+}
+
+// addToTimeline adds the post to the timeline of userID.
+func (s *memoryStore) addToTimeline(userID, postID int) {
+	if s.timelines[userID] == nil {
+		s.timelines[userID] = map[int]bool{}
+	}
+	s.timelines[userID][postID] = true
+}
+
+func (s *memoryStore) FanOutPost(ctx context.Context, postID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[postID]
+	if !ok {
+		return nil
+	}
+	for key := range s.follows {
+		if key[1] == p.UserID {
+			s.addToTimeline(key[0], postID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) AddToTimeline(ctx context.Context, followerID, followeeID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.follows[[2]int{followerID, followeeID}]; !ok {
+		return nil
+	}
+	for id, p := range s.posts {
+		if p.UserID == followeeID {
+			s.addToTimeline(followerID, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveFromTimeline(ctx context.Context, followerID, followeeID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.timelines[followerID] {
+		if p, ok := s.posts[id]; !ok || p.UserID == followeeID {
+			delete(s.timelines[followerID], id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RebuildTimelines(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timelines = map[int]map[int]bool{}
+	for key := range s.follows {
+		for id, p := range s.posts {
+			if p.UserID == key[1] {
+				s.addToTimeline(key[0], id)
+			}
+		}
+	}
+	return nil
+}
+
+// findReaction returns the ID of a reaction, or 0 if there is none.
+func (s *memoryStore) findReaction(target ReactionTarget, targetID, userID int, reaction string) int {
+	for id, r := range s.reactions {
+		if r.target == target && r.targetID == targetID && r.UserID == userID && r.Reaction.Reaction == reaction {
+			return id
+		}
+	}
+	return 0
+}
+
+// deleteReactions removes the reactions to a target, as the foreign key
+// cascades of sqlStore do.
+func (s *memoryStore) deleteReactions(target ReactionTarget, targetID int) {
+	for id, r := range s.reactions {
+		if r.target == target && r.targetID == targetID {
+			delete(s.reactions, id)
+		}
+	}
+}
+
+// deleteNotifications removes the notifications about a post or a comment,
+// as the foreign key cascades of sqlStore do.
+func (s *memoryStore) deleteNotifications(postID, commentID int) {
+	for id, n := range s.notifications {
+		if (postID != 0 && n.PostID == postID) || (commentID != 0 && n.CommentID == commentID) {
+			delete(s.notifications, id)
+		}
+	}
+}
+
+func (s *memoryStore) AddReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if target == ReactToPost {
+		if _, ok := s.posts[targetID]; !ok {
+			return &ReferenceError{Field: "postID"}
+		}
+	} else if _, ok := s.comments[targetID]; !ok {
+		return &ReferenceError{Field: "commentID"}
+	}
+	user, ok := s.users[userID]
+	if !ok {
+		return &ReferenceError{Field: "userID"}
+	}
+	if s.findReaction(target, targetID, userID, reaction) != 0 {
+		return nil
+	}
+
+	s.lastReactionID++
+	s.reactions[s.lastReactionID] = &memoryReaction{
+		Reaction: Reaction{
+			IDReaction:  s.lastReactionID,
+			UserID:      userID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Reaction:    reaction,
+			CreatedAt:   formatTime(at),
+		},
+		target:   target,
+		targetID: targetID,
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.findReaction(target, targetID, userID, reaction)
+	if id == 0 {
+		return ErrNotFound
+	}
+	delete(s.reactions, id)
+	return nil
+}
+
+func (s *memoryStore) ListReactions(ctx context.Context, target ReactionTarget, targetID int, reaction string, page PageRequest) ([]Reaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reactions []Reaction
+	for _, r := range s.reactions {
+		if r.target != target || r.targetID != targetID || (reaction != "" && r.Reaction.Reaction != reaction) {
+			continue
+		}
+		// Names are looked up now, as the join of sqlStore does
+		user := s.users[r.UserID]
+		entry := r.Reaction
+		entry.Username, entry.DisplayName = user.Username, user.DisplayName
+		reactions = append(reactions, entry)
+	}
+	return pageOf(reactions, page, reactionCursor), nil
+}
+
+func (s *memoryStore) ReactionSummaries(ctx context.Context, target ReactionTarget, targetIDs []int, viewerID int) (map[int]ReactionSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := map[int]ReactionSummary{}
+	for _, id := range targetIDs {
+		summary := ReactionSummary{Counts: map[string]int{}}
+		for _, r := range s.reactions {
+			if r.target != target || r.targetID != id {
+				continue
+			}
+			summary.Counts[r.Reaction.Reaction]++
+			if r.UserID == viewerID {
+				summary.Reacted = append(summary.Reacted, r.Reaction.Reaction)
+			}
+		}
+		if len(summary.Counts) > 0 {
+			sort.Strings(summary.Reacted)
+			summaries[id] = summary
+		}
+	}
+	return summaries, nil
+}
+
+func (s *memoryStore) Notify(ctx context.Context, e NotificationEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := formatTime(e.At)
+	for _, n := range s.notifications {
+		if n.userID != e.UserID || n.Kind != e.Kind || n.PostID != e.PostID || n.CommentID != e.CommentID || n.ReadAt != nil {
+			continue
+		}
+		if !n.actors[e.ActorID] {
+			n.actors[e.ActorID] = true
+			n.ActorID = e.ActorID
+			n.ActorCount++
+			n.UpdatedAt = at
+		}
+		return nil
+	}
+
+	s.lastNotificationID++
+	s.notifications[s.lastNotificationID] = &memoryNotification{
+		Notification: Notification{
+			IDNotification: s.lastNotificationID,
+			Kind:           e.Kind,
+			PostID:         e.PostID,
+			CommentID:      e.CommentID,
+			ActorID:        e.ActorID,
+			ActorCount:     1,
+			UpdatedAt:      at,
+		},
+		userID: e.UserID,
+		actors: map[int]bool{e.ActorID: true},
+	}
+	return nil
+}
+
+func (s *memoryStore) ListNotifications(ctx context.Context, userID int, unreadOnly bool, page PageRequest) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var notifications []Notification
+	for _, n := range s.notifications {
+		if n.userID != userID || (unreadOnly && n.ReadAt != nil) {
+			continue
+		}
+		entry := n.Notification
+		entry.ActorName = s.users[n.ActorID].DisplayName
+		notifications = append(notifications, entry)
+	}
+	return pageOf(notifications, page, notificationCursor), nil
+}
+
+func (s *memoryStore) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, n := range s.notifications {
+		if n.userID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) MarkNotificationRead(ctx context.Context, userID, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notifications[id]
+	if !ok || n.userID != userID {
+		return ErrNotFound
+	}
+	if n.ReadAt == nil {
+		readAt := formatTime(at)
+		n.ReadAt = &readAt
+	}
+	return nil
+}
+
+func (s *memoryStore) MarkAllNotificationsRead(ctx context.Context, userID int, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	readAt := formatTime(at)
+	count := 0
+	for _, n := range s.notifications {
+		if n.userID == userID && n.ReadAt == nil {
+			n.ReadAt = &readAt
+			count++
+		}
+	}
+	return count, nil
+}
+
+// deleteLinks removes the mentions and hashtags of a comment, or of a post
+// and its comments if ref.CommentID is 0.
+func (s *memoryStore) deleteLinks(ref ContentRef) {
+	s.links = slices.DeleteFunc(s.links, func(l memoryLink) bool {
+		if ref.CommentID != 0 {
+			return l.CommentID == ref.CommentID
+		}
+		return l.PostID == ref.PostID
+	})
+}
+
+func (s *memoryStore) SetContentLinks(ctx context.Context, ref ContentRef, links ContentLinks) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var createdAt string
+	if ref.CommentID != 0 {
+		c, ok := s.comments[ref.CommentID]
+		if !ok {
+			return nil, nil
+		}
+		ref.PostID, createdAt = c.IDPost, c.CreatedAt
+	} else if p, ok := s.posts[ref.PostID]; ok {
+		createdAt = p.CreatedAt
+	} else {
+		return nil, nil
+	}
+
+	before := map[int]bool{}
+	s.links = slices.DeleteFunc(s.links, func(l memoryLink) bool {
+		if l.ContentRef != ref {
+			return false
+		}
+		if l.userID != 0 {
+			before[l.userID] = true
+		}
+		return true
+	})
+
+	for _, tag := range links.Tags {
+		s.links = append(s.links, memoryLink{ContentRef: ref, tag: tag, createdAt: createdAt})
+	}
+	var added []int
+	for _, id := range sortedKeys(s.users) {
+		if !slices.Contains(links.Usernames, s.users[id].Username) {
+			continue
+		}
+		s.links = append(s.links, memoryLink{ContentRef: ref, userID: id, createdAt: createdAt})
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	return added, nil
+}
+
+func (s *memoryStore) ListPostsByTag(ctx context.Context, tag string, page PageRequest) ([]Post, error) {
+	s.mu.RLock()
+	tagged := map[int]bool{}
+	for _, l := range s.links {
+		if l.tag == tag && l.CommentID == 0 {
+			tagged[l.PostID] = true
+		}
+	}
+	s.mu.RUnlock()
+
+	return s.filterPosts(page, func(p *Post) bool { return tagged[p.IDPost] && !s.hides(0, p.UserID) }), nil
+}
+
+func (s *memoryStore) TrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := formatTime(since)
+	uses := map[string]int{}
+	for _, l := range s.links {
+		if l.tag == "" || l.createdAt < cutoff {
+			continue
+		}
+		if p, ok := s.posts[l.PostID]; ok && p.DeletedAt == nil {
+			uses[l.tag]++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(uses))
+	for tag, n := range uses {
+		tags = append(tags, TagCount{Tag: tag, Uses: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Uses != tags[j].Uses {
+			return tags[i].Uses > tags[j].Uses
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags[:min(limit, len(tags))], nil
+}
+
+func (s *memoryStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.posts[a.PostID]; !ok {
+		return &ReferenceError{Field: "postID"}
+	}
+	s.lastAttachmentID++
+	a.IDAttachment = s.lastAttachmentID
+	stored := *a
+	s.attachments[a.IDAttachment] = &stored
+	return nil
+}
+
+func (s *memoryStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.attachments[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	return *a, nil
+}
+
+func (s *memoryStore) ListAttachments(ctx context.Context, postIDs []int) (map[int][]Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachments := map[int][]Attachment{}
+	for _, id := range sortedKeys(s.attachments) {
+		a := s.attachments[id]
+		if slices.Contains(postIDs, a.PostID) {
+			attachments[a.PostID] = append(attachments[a.PostID], *a)
+		}
+	}
+	return attachments, nil
+}
+
+func (s *memoryStore) UpdateAttachmentAltText(ctx context.Context, id int, altText string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attachments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	a.AltText = altText
+	return nil
+}
+
+func (s *memoryStore) DeleteAttachment(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.attachments[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.attachments, id)
+	return nil
+}
+
+func (s *memoryStore) CreateConversation(ctx context.Context, c *Conversation, memberIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userID := range memberIDs {
+		if _, ok := s.users[userID]; !ok {
+			return &ReferenceError{Field: "userID"}
+		}
+	}
+	key := ""
+	if c.Direct {
+		key = directKey(memberIDs[0], memberIDs[1])
+		for _, existing := range s.conversations {
+			if existing.directKey == key {
+				c.IDConversation = existing.IDConversation
+				return nil
+			}
+		}
+	}
+
+	s.lastConversationID++
+	c.IDConversation = s.lastConversationID
+	stored := &memoryConversation{Conversation: *c, directKey: key, members: map[int]*ConversationMember{}}
+	for _, userID := range memberIDs {
+		stored.members[userID] = &ConversationMember{UserID: userID, JoinedAt: c.CreatedAt}
+	}
+	s.conversations[c.IDConversation] = stored
+	return nil
+}
+
+// conversationView is what viewerID sees of c. The caller holds s.mu.
+func (s *memoryStore) conversationView(c *memoryConversation, viewerID int) Conversation {
+	view := c.Conversation
+	view.Members = nil
+	for _, userID := range sortedKeys(c.members) {
+		m := *c.members[userID]
+		m.Username = s.users[userID].Username
+		m.DisplayName = s.users[userID].DisplayName
+		view.Members = append(view.Members, m)
+	}
+	sort.SliceStable(view.Members, func(i, j int) bool { return view.Members[i].JoinedAt < view.Members[j].JoinedAt })
+
+	lastRead := c.members[viewerID].LastReadMessageID
+	for _, id := range sortedKeys(s.messages) {
+		m := s.messages[id]
+		if m.ConversationID != c.IDConversation {
+			continue
+		}
+		message := *m
+		view.LastMessage = &message
+		if m.IDMessage > lastRead && m.SenderID != viewerID {
+			view.UnreadCount++
+		}
+	}
+	return view
+}
+
+func (s *memoryStore) GetConversation(ctx context.Context, id, viewerID int) (Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.conversations[id]
+	if !ok || c.members[viewerID] == nil {
+		return Conversation{}, ErrNotFound
+	}
+	return s.conversationView(c, viewerID), nil
+}
+
+func (s *memoryStore) ListConversations(ctx context.Context, userID int, page PageRequest) ([]Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var conversations []Conversation
+	for _, c := range s.conversations {
+		if c.members[userID] != nil {
+			conversations = append(conversations, s.conversationView(c, userID))
+		}
+	}
+	return pageOf(conversations, page, conversationCursor), nil
+}
+
+func (s *memoryStore) CreateMessage(ctx context.Context, m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[m.ConversationID]
+	if !ok || c.members[m.SenderID] == nil {
+		return ErrNotFound
+	}
+	s.lastMessageID++
+	m.IDMessage = s.lastMessageID
+	stored := *m
+	s.messages[m.IDMessage] = &stored
+	c.UpdatedAt = m.CreatedAt
+	sender := c.members[m.SenderID]
+	sender.LastReadMessageID = m.IDMessage
+	readAt := m.CreatedAt
+	sender.ReadAt = &readAt
+	return nil
+}
+
+func (s *memoryStore) ListMessages(ctx context.Context, conversationID int, page PageRequest) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []Message
+	for _, m := range s.messages {
+		if m.ConversationID == conversationID {
+			messages = append(messages, *m)
+		}
+	}
+	return pageOf(messages, page, messageCursor), nil
+}
+
+func (s *memoryStore) MarkConversationRead(ctx context.Context, conversationID, userID, messageID int, at time.Time) (ReadReceipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if messageID == 0 {
+		for _, m := range s.messages {
+			if m.ConversationID == conversationID {
+				messageID = max(messageID, m.IDMessage)
+			}
+		}
+	} else if m, ok := s.messages[messageID]; !ok || m.ConversationID != conversationID {
+		return ReadReceipt{}, ErrNotFound
+	}
+	c, ok := s.conversations[conversationID]
+	if !ok || c.members[userID] == nil {
+		return ReadReceipt{}, ErrNotFound
+	}
+	member := c.members[userID]
+	if messageID > member.LastReadMessageID {
+		member.LastReadMessageID = messageID
+		readAt := formatTime(at)
+		member.ReadAt = &readAt
+	}
+	receipt := ReadReceipt{ConversationID: conversationID, UserID: userID, LastReadMessageID: member.LastReadMessageID}
+	if member.ReadAt != nil {
+		receipt.ReadAt = *member.ReadAt
+	}
+	return receipt, nil
+}
+
+// hides reports whether the content of authorID is left out of the lists
+// of viewerID: because of a restriction, or a suspension that hides it. The
+// caller must hold s.mu.
+func (s *memoryStore) hides(viewerID, authorID int) bool {
+	if u, ok := s.users[authorID]; ok && u.suspension != nil && u.suspension.HideContent && u.suspension.activeAt(time.Now()) {
+		return true
+	}
+	return s.restricts(viewerID, authorID)
+}
+
+// restricts reports whether userID blocked or muted targetID. The caller
+// must hold s.mu.
+func (s *memoryStore) restricts(userID, targetID int) bool {
+	_, blocked := s.restrictions[memoryRestriction{userID, targetID, RestrictBlock}]
+	_, muted := s.restrictions[memoryRestriction{userID, targetID, RestrictMute}]
+	return blocked || muted
+}
+
+func (s *memoryStore) Restrict(ctx context.Context, kind Restriction, userID, targetID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[targetID]; !ok {
+		return &ReferenceError{Field: "userID"}
+	}
+	key := memoryRestriction{userID, targetID, kind}
+	if _, ok := s.restrictions[key]; !ok {
+		s.restrictions[key] = formatTime(at)
+	}
+	if kind == RestrictBlock {
+		delete(s.follows, [2]int{userID, targetID})
+		delete(s.follows, [2]int{targetID, userID})
+	}
+	return nil
+}
+
+func (s *memoryStore) Unrestrict(ctx context.Context, kind Restriction, userID, targetID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memoryRestriction{userID, targetID, kind}
+	if _, ok := s.restrictions[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.restrictions, key)
+	return nil
+}
+
+func (s *memoryStore) ListRestricted(ctx context.Context, kind Restriction, userID int, page PageRequest) ([]RestrictedUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []RestrictedUser
+	for key, createdAt := range s.restrictions {
+		if key.userID == userID && key.kind == kind {
+			users = append(users, RestrictedUser{User: s.users[key.targetID].User, CreatedAt: createdAt})
+		}
+	}
+	return pageOf(users, page, restrictedCursor), nil
+}
+
+func (s *memoryStore) Restricts(ctx context.Context, userID, targetID int, kind Restriction) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if kind == "" {
+		return s.restricts(userID, targetID), nil
+	}
+	_, ok := s.restrictions[memoryRestriction{userID, targetID, kind}]
+	return ok, nil
+}
+
+func (s *memoryStore) CreateReport(ctx context.Context, r *Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.reports {
+		if existing.ReporterID == r.ReporterID && existing.PostID == r.PostID &&
+			equalIDs(existing.CommentID, r.CommentID) && existing.Status != ReportResolved {
+			return &ConflictError{Field: "report"}
+		}
+	}
+	s.lastReportID++
+	r.IDReport = s.lastReportID
+	stored := *r
+	s.reports[r.IDReport] = &stored
+	return nil
+}
+
+// equalIDs reports whether two optional IDs are both nil or equal.
+func equalIDs(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (s *memoryStore) GetReport(ctx context.Context, id int) (Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.reports[id]
+	if !ok {
+		return Report{}, ErrNotFound
+	}
+	return *r, nil
+}
+
+func (s *memoryStore) ListReports(ctx context.Context, status ReportStatus, page PageRequest) ([]Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reports []Report
+	for _, r := range s.reports {
+		if r.Status == status {
+			reports = append(reports, *r)
+		}
+	}
+	return pageOf(reports, page, reportCursor), nil
+}
+
+// availableReport returns the report if moderatorID can work on it. The
+// caller must hold s.mu.
+func (s *memoryStore) availableReport(id, moderatorID int) (*Report, error) {
+	r, ok := s.reports[id]
+	if !ok || r.Status == ReportResolved || r.ClaimedBy != nil && *r.ClaimedBy != moderatorID {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *memoryStore) ClaimReport(ctx context.Context, id, moderatorID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.availableReport(id, moderatorID)
+	if err != nil {
+		return err
+	}
+	r.Status = ReportClaimed
+	if r.ClaimedBy == nil {
+		claimedAt := formatTime(at)
+		r.ClaimedBy, r.ClaimedAt = &moderatorID, &claimedAt
+	}
+	return nil
+}
+
+func (s *memoryStore) ResolveReport(ctx context.Context, id, moderatorID int, action ModerationAction, note string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.availableReport(id, moderatorID)
+	if err != nil {
+		return err
+	}
+	now := formatTime(at)
+	if r.ClaimedBy == nil {
+		r.ClaimedBy, r.ClaimedAt = &moderatorID, &now
+	}
+	r.Status = ReportResolved
+	r.ResolvedBy, r.ResolvedAt = &moderatorID, &now
+	r.Action = action
+	r.Note = note
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// The store contract also runs against PostgreSQL when TEST_POSTGRES_URL
+// names a database, e.g.
+//
+//	TEST_POSTGRES_URL=postgres://localhost/praxprojekt_test?sslmode=disable go test ./...
+//
+// Every test drops and recreates the public schema of that database, so it
+// must be one that is only used for testing.
+const postgresTestURLEnv = "TEST_POSTGRES_URL"
+
+func init() {
+	if os.Getenv(postgresTestURLEnv) != "" {
+		storeFactories = append(storeFactories, storeFactory{"postgres", openTestPostgresStore})
+	}
+}
+
+// openTestPostgresStore empties the test database and migrates it.
+func openTestPostgresStore(t *testing.T) Store {
+	s, err := openPostgresStore(os.Getenv(postgresTestURLEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUp(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostgresRebind(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM posts WHERE idPost = ?`, `SELECT * FROM posts WHERE idPost = $1`},
+		{`UPDATE posts SET content_text = ? WHERE idPost = ? AND idUser = ?`, `UPDATE posts SET content_text = $1 WHERE idPost = $2 AND idUser = $3`},
+		{`SELECT '…' || ? LIMIT ? OFFSET ?`, `SELECT '…' || $1 LIMIT $2 OFFSET $3`},
+	} {
+		if got := (postgresDialect{}).rebind(c.query); got != c.want {
+			t.Errorf("rebind(%q) = %q, want %q", c.query, got, c.want)
+		}
+	}
+}
+
+// TestMigrationsRoundTrip reverts every migration of a migrated database and
+// applies them again, which checks that each down undoes its up.
+func TestMigrationsRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		s, ok := store.(*sqlStore)
+		if !ok {
+			t.Skip("not an SQL store")
+		}
+		migrations := s.dialect.migrations()
+		if got := migrations[len(migrations)-1].version; got != len(migrations) {
+			t.Fatalf("last migration is %d, want %d", got, len(migrations))
+		}
+		if err := migrateDown(s, len(migrations)); err != nil {
+			t.Fatal(err)
+		}
+		if applied, err := appliedMigrations(s); err != nil || len(applied) != 0 {
+			t.Fatalf("applied after reverting all = %v, %v", applied, err)
+		}
+		if err := migrateUp(s); err != nil {
+			t.Fatal(err)
+		}
+		if applied, err := appliedMigrations(s); err != nil || len(applied) != len(migrations) {
+			t.Fatalf("applied after migrating again = %v, %v", applied, err)
+		}
+	})
+}
@@ -0,0 +1,1603 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlStore is the Store backed by a SQL database. The same queries serve
+// SQLite and PostgreSQL; dialect smooths over the differences.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// sqliteDriver is go-sqlite3 with the functions our queries need registered
+// on every connection.
+const sqliteDriver = "sqlite3_praxprojekt"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("fold", sqliteFold, true)
+		},
+	})
+}
+
+// sqliteFold is the SQL function fold(text), which lowercases text with
+// strings.ToLower. NULL and other non-text values are returned unchanged.
+func sqliteFold(value any) any {
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(v)
+	case []byte:
+		return strings.ToLower(string(v))
+	}
+	return value
+}
+
+// openSQLiteStore opens the SQLite file at path. SQLite only enforces
+// foreign keys on connections that ask for it, so the DSN turns them on for
+// every connection of the pool. It also makes writers wait for the lock
+// instead of failing with "database is locked", and makes transactions take
+// the write lock when they begin: a deferred transaction that reads first
+// cannot wait for it later without risking a deadlock.
+func openSQLiteStore(path string) (*sqlStore, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	s, err := openSQLStore(sqliteDriver, path+sep+"_foreign_keys=on&_busy_timeout=5000&_txlock=immediate", sqliteDialect{})
+	if err != nil {
+		return nil, err
+	}
+	if err := initSearchIndex(s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// fts5 reports whether the store is SQLite with FTS5 compiled in.
+func (s *sqlStore) fts5() bool {
+	d, ok := s.dialect.(sqliteDialect)
+	return ok && d.fts5
+}
+
+func openPostgresStore(dsn string) (*sqlStore, error) {
+	return openSQLStore("postgres", dsn, postgresDialect{})
+}
+
+func openSQLStore(driver, dsn string, d dialect) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s: %w", d.name(), err)
+	}
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// sqlTx offers the query helpers of sqlStore inside a transaction.
+type sqlTx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t sqlTx) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t sqlTx) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t sqlTx) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.tx.QueryRowContext(ctx, t.dialect.rebind(query), args...)
+}
+
+// queryIDs runs a query that selects one integer column.
+func (t sqlTx) queryIDs(ctx context.Context, query string, args ...any) ([]int, error) {
+	rows, err := t.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// inTx runs fn in a transaction that is committed if fn returns nil and
+// rolled back otherwise.
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx sqlTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(sqlTx{tx: tx, dialect: s.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// expectAffected turns an UPDATE or DELETE that matched no rows into ErrNotFound.
+func expectAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// reference is a foreign key of a row being written, for missingReference.
+type reference struct {
+	field  string
+	table  string
+	column string
+	id     int
+}
+
+// missingReference converts a foreign key violation into a *ReferenceError
+// for the first of refs that points nowhere. SQLite does not report which
+// key failed, so every reference is looked up. Other errors are returned
+// unchanged.
+func (s *sqlStore) missingReference(ctx context.Context, err error, refs ...reference) error {
+	if !s.dialect.foreignKeyViolation(err) {
+		return err
+	}
+	for _, ref := range refs {
+		var exists int
+		query := `SELECT 1 FROM ` + ref.table + ` WHERE ` + ref.column + ` = ?`
+		if e := s.queryRow(ctx, query, ref.id).Scan(&exists); errors.Is(e, sql.ErrNoRows) {
+			return &ReferenceError{Field: ref.field}
+		}
+	}
+	return err
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// timestamp scans a timestamp column into an RFC3339 string. SQLite stores
+// the RFC3339 text as-is, while Postgres has a native timestamptz type that
+// the driver returns as time.Time.
+type timestamp struct {
+	dest *string
+}
+
+func (t timestamp) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*t.dest = ""
+	case time.Time:
+		*t.dest = formatTime(v)
+	case string:
+		*t.dest = v
+	case []byte:
+		*t.dest = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into timestamp", value)
+	}
+	return nil
+}
+
+// keyset returns the WHERE condition, its arguments and the ORDER BY ... LIMIT
+// tail that select page from rows ordered by timeColumn, then idColumn. An
+// empty timeColumn orders by idColumn alone.
+func keyset(page PageRequest, timeColumn, idColumn string) (string, []any, string) {
+	op, dir := ">", "ASC"
+	if page.Sort == SortNewest {
+		op, dir = "<", "DESC"
+	}
+
+	order := " ORDER BY " + idColumn + " " + dir + " LIMIT ?"
+	if timeColumn != "" {
+		order = " ORDER BY " + timeColumn + " " + dir + ", " + idColumn + " " + dir + " LIMIT ?"
+	}
+
+	if page.After == nil {
+		return "", nil, order
+	}
+	if timeColumn == "" {
+		return idColumn + " " + op + " ?", []any{page.After.ID}, order
+	}
+	cond := "(" + timeColumn + " " + op + " ? OR (" + timeColumn + " = ? AND " + idColumn + " " + op + " ?))"
+	return cond, []any{page.After.CreatedAt, page.After.CreatedAt, page.After.ID}, order
+}
+
+// placeholders returns n comma-separated placeholders.
+func placeholders(n int) string {
+	return "?" + strings.Repeat(", ?", n-1)
+}
+
+// where joins the non-empty conditions into a WHERE clause.
+func where(conds ...string) string {
+	clause := ""
+	for _, cond := range conds {
+		if cond == "" {
+			continue
+		}
+		if clause == "" {
+			clause = " WHERE " + cond
+		} else {
+			clause += " AND " + cond
+		}
+	}
+	return clause
+}
+
+// visibleAuthors returns the condition that leaves out the rows whose
+// authorColumn is a user viewerID blocked or muted, or a user whose
+// suspension hides their content, and its arguments. Anonymous viewers only
+// get the latter.
+func visibleAuthors(viewerID int, authorColumn string) (string, []any) {
+	cond := authorColumn + ` NOT IN (SELECT idUser FROM users
+		WHERE suspension_hides_content AND suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > ?))`
+	args := []any{formatTime(time.Now())}
+	if viewerID == 0 {
+		return cond, args
+	}
+	cond += " AND " + authorColumn + " NOT IN (SELECT targetID FROM restrictions WHERE userID = ?)"
+	return cond, append(args, viewerID)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const userColumns = `idUser, username, displayName, email, role`
+
+func scanUser(row scanner, extra ...any) (User, error) {
+	var u User
+	dest := append([]any{&u.IDUser, &u.Username, &u.DisplayName, &u.Email, &u.Role}, extra...)
+	err := row.Scan(dest...)
+	return u, err
+}
+
+func (s *sqlStore) CreateUser(ctx context.Context, u *User, passwordHash string) error {
+	query := `INSERT INTO users (username, displayName, email, password, role) VALUES (?, ?, ?, ?, ?) RETURNING idUser`
+	err := s.queryRow(ctx, query, u.Username, u.DisplayName, u.Email, passwordHash, u.Role).Scan(&u.IDUser)
+	return s.dialect.uniqueViolation(err)
+}
+
+func (s *sqlStore) GetUser(ctx context.Context, id int) (User, error) {
+	u, err := scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE idUser = ?`, id))
+	return u, notFound(err)
+}
+
+func (s *sqlStore) GetUserByLogin(ctx context.Context, login string) (User, string, error) {
+	query := `SELECT ` + userColumns + `, password FROM users WHERE username = ? OR email = ?`
+	var password sql.NullString
+	u, err := scanUser(s.queryRow(ctx, query, login, login), &password)
+	return u, password.String, notFound(err)
+}
+
+func (s *sqlStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	u, err := scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+	return u, notFound(err)
+}
+
+func (s *sqlStore) ListUsers(ctx context.Context, page PageRequest) ([]User, error) {
+	cond, args, order := keyset(page, "", "idUser")
+	rows, err := s.query(ctx, `SELECT `+userColumns+` FROM users`+where(cond)+order, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) UpdateUser(ctx context.Context, u User) error {
+	query := `UPDATE users SET username = ?, displayName = ?, email = ? WHERE idUser = ?`
+	result, err := s.exec(ctx, query, u.Username, u.DisplayName, u.Email, u.IDUser)
+	if err != nil {
+		return s.dialect.uniqueViolation(err)
+	}
+	return expectAffected(result, nil)
+}
+
+func (s *sqlStore) SetUserRole(ctx context.Context, id int, role Role) error {
+	return expectAffected(s.exec(ctx, `UPDATE users SET role = ? WHERE idUser = ?`, role, id))
+}
+
+func (s *sqlStore) SetUserPassword(ctx context.Context, id int, passwordHash string) error {
+	return expectAffected(s.exec(ctx, `UPDATE users SET password = ? WHERE idUser = ?`, passwordHash, id))
+}
+
+func (s *sqlStore) SuspendUser(ctx context.Context, id int, suspension Suspension) error {
+	query := `UPDATE users SET suspended_at = ?, suspended_until = ?, suspension_reason = ?, suspendedBy = ?, suspension_hides_content = ?
+		WHERE idUser = ?`
+	return expectAffected(s.exec(ctx, query, suspension.SuspendedAt, suspension.Until, suspension.Reason,
+		suspension.ModeratorID, suspension.HideContent, id))
+}
+
+func (s *sqlStore) GetSuspension(ctx context.Context, id int) (Suspension, error) {
+	query := `SELECT suspended_at, suspended_until, suspension_reason, COALESCE(suspendedBy, 0), suspension_hides_content
+		FROM users WHERE idUser = ? AND suspended_at IS NOT NULL`
+	var suspension Suspension
+	var until string
+	err := s.queryRow(ctx, query, id).Scan(timestamp{&suspension.SuspendedAt}, timestamp{&until}, &suspension.Reason,
+		&suspension.ModeratorID, &suspension.HideContent)
+	if until != "" {
+		suspension.Until = &until
+	}
+	return suspension, notFound(err)
+}
+
+func (s *sqlStore) LiftSuspension(ctx context.Context, id int) error {
+	query := `UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = '', suspendedBy = NULL,
+		suspension_hides_content = FALSE
+		WHERE idUser = ? AND suspended_at IS NOT NULL`
+	return expectAffected(s.exec(ctx, query, id))
+}
+
+func (s *sqlStore) CreateSession(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error {
+	_, err := s.exec(ctx, `INSERT INTO sessions (token_hash, idUser, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		tokenHash, userID, formatTime(createdAt), formatTime(expiresAt))
+	return err
+}
+
+func (s *sqlStore) GetSessionUser(ctx context.Context, tokenHash string) (User, time.Time, error) {
+	query := `SELECT u.idUser, u.username, u.displayName, u.email, u.role, s.expires_at
+		FROM sessions s JOIN users u ON u.idUser = s.idUser
+		WHERE s.token_hash = ?`
+	var expiresAt string
+	u, err := scanUser(s.queryRow(ctx, query, tokenHash), timestamp{&expiresAt})
+	if err != nil {
+		return User{}, time.Time{}, notFound(err)
+	}
+	expires, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return User{}, time.Time{}, err
+	}
+	return u, expires, nil
+}
+
+func (s *sqlStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.exec(ctx, `DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func (s *sqlStore) DeleteUserSessions(ctx context.Context, userID int) error {
+	_, err := s.exec(ctx, `DELETE FROM sessions WHERE idUser = ?`, userID)
+	return err
+}
+
+func (s *sqlStore) CreatePasswordReset(ctx context.Context, tokenHash string, userID int, createdAt, expiresAt time.Time) error {
+	_, err := s.exec(ctx, `INSERT INTO password_resets (token_hash, idUser, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		tokenHash, userID, formatTime(createdAt), formatTime(expiresAt))
+	return err
+}
+
+func (s *sqlStore) ResetPassword(ctx context.Context, tokenHash, passwordHash string, at time.Time) (int, error) {
+	var userID int
+	now := formatTime(at)
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		query := `UPDATE password_resets SET used_at = ?
+			WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING idUser`
+		if err := tx.queryRow(ctx, query, now, tokenHash, now).Scan(&userID); err != nil {
+			return notFound(err)
+		}
+		if _, err := tx.exec(ctx, `UPDATE password_resets SET used_at = ? WHERE idUser = ? AND used_at IS NULL`, now, userID); err != nil {
+			return err
+		}
+		if err := expectAffected(tx.exec(ctx, `UPDATE users SET password = ? WHERE idUser = ?`, passwordHash, userID)); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, `DELETE FROM sessions WHERE idUser = ?`, userID)
+		return err
+	})
+	return userID, err
+}
+
+const postColumns = `idPost, content_text, created_at, userID, deleted_at, hidden_at`
+
+func scanPost(row scanner) (Post, error) {
+	var p Post
+	var deletedAt, hiddenAt string
+	err := row.Scan(&p.IDPost, &p.ContentText, timestamp{&p.CreatedAt}, &p.UserID, timestamp{&deletedAt}, timestamp{&hiddenAt})
+	if deletedAt != "" {
+		p.DeletedAt = &deletedAt
+	}
+	if hiddenAt != "" {
+		p.HiddenAt = &hiddenAt
+	}
+	return p, err
+}
+
+func (s *sqlStore) queryPosts(ctx context.Context, query string, args ...any) ([]Post, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (s *sqlStore) CreatePost(ctx context.Context, p *Post) error {
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		query := `INSERT INTO posts (userID, content_text, created_at) VALUES (?, ?, ?) RETURNING idPost`
+		if err := tx.queryRow(ctx, query, p.UserID, p.ContentText, p.CreatedAt).Scan(&p.IDPost); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, `INSERT INTO post_revisions (idPost, revision, editorID, content_text, created_at) VALUES (?, 1, ?, ?, ?)`,
+			p.IDPost, p.UserID, p.ContentText, p.CreatedAt)
+		return err
+	})
+	return s.missingReference(ctx, err, reference{"userID", "users", "idUser", p.UserID})
+}
+
+func (s *sqlStore) GetPost(ctx context.Context, id int) (Post, error) {
+	query := `SELECT ` + postColumns + ` FROM posts WHERE idPost = ? AND deleted_at IS NULL`
+	p, err := scanPost(s.queryRow(ctx, query, id))
+	return p, notFound(err)
+}
+
+func (s *sqlStore) ListPosts(ctx context.Context, viewerID int, page PageRequest) ([]Post, error) {
+	visible, args := visibleAuthors(viewerID, "userID")
+	cond, pageArgs, order := keyset(page, "created_at", "idPost")
+	args = append(args, pageArgs...)
+	query := `SELECT ` + postColumns + ` FROM posts` + where("deleted_at IS NULL", visible, cond) + order
+	return s.queryPosts(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) ListPostsByUser(ctx context.Context, userID, viewerID int, page PageRequest) ([]Post, error) {
+	visible, visibleArgs := visibleAuthors(viewerID, "userID")
+	cond, pageArgs, order := keyset(page, "created_at", "idPost")
+	args := append(append([]any{userID}, visibleArgs...), pageArgs...)
+	query := `SELECT ` + postColumns + ` FROM posts` + where("userID = ?", "deleted_at IS NULL", visible, cond) + order
+	return s.queryPosts(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) UpdatePostContent(ctx context.Context, id int, content string, editorID int, editedAt time.Time) error {
+	return s.inTx(ctx, func(tx sqlTx) error {
+		query := `UPDATE posts SET content_text = ? WHERE idPost = ? AND deleted_at IS NULL`
+		if err := expectAffected(tx.exec(ctx, query, content, id)); err != nil {
+			return err
+		}
+		var revision int
+		if err := tx.queryRow(ctx, `SELECT COALESCE(MAX(revision), 0) + 1 FROM post_revisions WHERE idPost = ?`, id).Scan(&revision); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, `INSERT INTO post_revisions (idPost, revision, editorID, content_text, created_at) VALUES (?, ?, ?, ?, ?)`,
+			id, revision, editorID, content, formatTime(editedAt))
+		return err
+	})
+}
+
+const revisionColumns = `idPost, revision, editorID, content_text, created_at`
+
+func scanPostRevision(row scanner) (PostRevision, error) {
+	var r PostRevision
+	err := row.Scan(&r.PostID, &r.Revision, &r.EditorID, &r.ContentText, timestamp{&r.CreatedAt})
+	return r, err
+}
+
+func (s *sqlStore) ListPostRevisions(ctx context.Context, postID int, page PageRequest) ([]PostRevision, error) {
+	cond, args, order := keyset(page, "", "revision")
+	args = append([]any{postID}, args...)
+	rows, err := s.query(ctx, `SELECT `+revisionColumns+` FROM post_revisions`+where("idPost = ?", cond)+order, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []PostRevision
+	for rows.Next() {
+		r, err := scanPostRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *sqlStore) GetPostRevision(ctx context.Context, postID, revision int) (PostRevision, error) {
+	query := `SELECT ` + revisionColumns + ` FROM post_revisions WHERE idPost = ? AND revision = ?`
+	r, err := scanPostRevision(s.queryRow(ctx, query, postID, revision))
+	return r, notFound(err)
+}
+
+func (s *sqlStore) DeletePost(ctx context.Context, id int, deletedAt time.Time) error {
+	query := `UPDATE posts SET deleted_at = ? WHERE idPost = ? AND deleted_at IS NULL`
+	return expectAffected(s.exec(ctx, query, formatTime(deletedAt), id))
+}
+
+func (s *sqlStore) GetDeletedPost(ctx context.Context, id int) (Post, error) {
+	query := `SELECT ` + postColumns + ` FROM posts WHERE idPost = ? AND deleted_at IS NOT NULL`
+	p, err := scanPost(s.queryRow(ctx, query, id))
+	return p, notFound(err)
+}
+
+func (s *sqlStore) RestorePost(ctx context.Context, id int) error {
+	return expectAffected(s.exec(ctx, `UPDATE posts SET deleted_at = NULL, hidden_at = NULL WHERE idPost = ? AND deleted_at IS NOT NULL`, id))
+}
+
+func (s *sqlStore) HidePost(ctx context.Context, id int, hiddenAt time.Time) error {
+	at := formatTime(hiddenAt)
+	query := `UPDATE posts SET deleted_at = COALESCE(deleted_at, ?), hidden_at = ? WHERE idPost = ? AND hidden_at IS NULL`
+	return expectAffected(s.exec(ctx, query, at, at, id))
+}
+
+func (s *sqlStore) PurgeDeletedPosts(ctx context.Context, deletedBefore time.Time) (int, []string, error) {
+	cutoff := formatTime(deletedBefore)
+	var purged int
+	var keys []string
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		rows, err := tx.query(ctx, `SELECT a.blob_key, a.thumbnail_key
+			FROM attachments a JOIN posts p ON p.idPost = a.idPost WHERE p.deleted_at < ? AND p.hidden_at IS NULL`, cutoff)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var blobKey, thumbnailKey string
+			if err := rows.Scan(&blobKey, &thumbnailKey); err != nil {
+				return err
+			}
+			keys = append(keys, blobKey, thumbnailKey)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		result, err := tx.exec(ctx, `DELETE FROM posts WHERE deleted_at < ? AND hidden_at IS NULL`, cutoff)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		purged = int(n)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return purged, keys, nil
+}
+
+const commentColumns = `idComment, idPost, parentCommentID, idUser, content_text, created_at, edited_at, deleted_at, hidden_at`
+
+func scanComment(row scanner) (Comment, error) {
+	var c Comment
+	var editedAt, deletedAt, hiddenAt string
+	err := row.Scan(&c.IDComment, &c.IDPost, &c.ParentID, &c.IDUser, &c.ContentText, timestamp{&c.CreatedAt},
+		timestamp{&editedAt}, timestamp{&deletedAt}, timestamp{&hiddenAt})
+	if editedAt != "" {
+		c.EditedAt = &editedAt
+	}
+	if deletedAt != "" {
+		c.Deleted = true
+		c.ContentText = deletedCommentText
+	}
+	if hiddenAt != "" {
+		c.Hidden = true
+		c.ContentText = hiddenCommentText
+	}
+	return c, err
+}
+
+func (s *sqlStore) queryComments(ctx context.Context, query string, args ...any) ([]Comment, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqlStore) CreateComment(ctx context.Context, c *Comment) error {
+	query := `INSERT INTO comments (idPost, parentCommentID, idUser, content_text, created_at) VALUES (?, ?, ?, ?, ?) RETURNING idComment`
+	err := s.queryRow(ctx, query, c.IDPost, c.ParentID, c.IDUser, c.ContentText, c.CreatedAt).Scan(&c.IDComment)
+	refs := []reference{
+		{"postID", "posts", "idPost", c.IDPost},
+		{"userID", "users", "idUser", c.IDUser},
+	}
+	if c.ParentID != nil {
+		refs = append(refs, reference{"parentCommentID", "comments", "idComment", *c.ParentID})
+	}
+	return s.missingReference(ctx, err, refs...)
+}
+
+func (s *sqlStore) GetComment(ctx context.Context, id int) (Comment, error) {
+	c, err := scanComment(s.queryRow(ctx, `SELECT `+commentColumns+` FROM comments WHERE idComment = ?`, id))
+	return c, notFound(err)
+}
+
+func (s *sqlStore) UpdateCommentContent(ctx context.Context, id int, content string, editedAt time.Time) error {
+	query := `UPDATE comments SET content_text = ?, edited_at = ? WHERE idComment = ? AND deleted_at IS NULL`
+	return expectAffected(s.exec(ctx, query, content, formatTime(editedAt), id))
+}
+
+func (s *sqlStore) DeleteComment(ctx context.Context, id int, deletedAt time.Time) error {
+	return s.inTx(ctx, func(tx sqlTx) error {
+		var parentID *int
+		query := `SELECT parentCommentID FROM comments WHERE idComment = ? AND deleted_at IS NULL`
+		if err := tx.queryRow(ctx, query, id).Scan(&parentID); err != nil {
+			return notFound(err)
+		}
+
+		var replies int
+		if err := tx.queryRow(ctx, `SELECT COUNT(*) FROM comments WHERE parentCommentID = ?`, id).Scan(&replies); err != nil {
+			return err
+		}
+		if replies > 0 {
+			_, err := tx.exec(ctx, `UPDATE comments SET content_text = '', deleted_at = ? WHERE idComment = ?`,
+				formatTime(deletedAt), id)
+			return err
+		}
+
+		if _, err := tx.exec(ctx, `DELETE FROM comments WHERE idComment = ?`, id); err != nil {
+			return err
+		}
+		// Tombstones only exist to hold their replies together; remove
+		// the ones this deletion left without replies. Hidden comments
+		// stay to show that a moderator removed something.
+		for parentID != nil {
+			var grandparentID *int
+			query := `SELECT parentCommentID FROM comments c
+				WHERE idComment = ? AND deleted_at IS NOT NULL AND hidden_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parentCommentID = c.idComment)`
+			err := tx.queryRow(ctx, query, *parentID).Scan(&grandparentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.exec(ctx, `DELETE FROM comments WHERE idComment = ?`, *parentID); err != nil {
+				return err
+			}
+			parentID = grandparentID
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) HideComment(ctx context.Context, id int, hiddenAt time.Time) error {
+	at := formatTime(hiddenAt)
+	query := `UPDATE comments SET content_text = '', deleted_at = COALESCE(deleted_at, ?), hidden_at = ? WHERE idComment = ? AND hidden_at IS NULL`
+	return expectAffected(s.exec(ctx, query, at, at, id))
+}
+
+func (s *sqlStore) ListCommentsByPost(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error) {
+	visible, visibleArgs := visibleAuthors(viewerID, "idUser")
+	cond, pageArgs, order := keyset(page, "created_at", "idComment")
+	args := append(append([]any{postID}, visibleArgs...), pageArgs...)
+	query := `SELECT ` + commentColumns + ` FROM comments` + where("idPost = ?", visible, cond) + order
+	return s.queryComments(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) ListRootComments(ctx context.Context, postID, viewerID int, page PageRequest) ([]Comment, error) {
+	visible, visibleArgs := visibleAuthors(viewerID, "idUser")
+	cond, pageArgs, order := keyset(page, "created_at", "idComment")
+	args := append(append([]any{postID}, visibleArgs...), pageArgs...)
+	query := `SELECT ` + commentColumns + ` FROM comments` + where("idPost = ?", "parentCommentID IS NULL", visible, cond) + order
+	return s.queryComments(ctx, query, append(args, page.Limit)...)
+}
+
+// ListReplies leaves the replies to hidden comments in; with their parent
+// missing, buildCommentTrees never reaches them.
+func (s *sqlStore) ListReplies(ctx context.Context, rootIDs []int, viewerID int) ([]Comment, error) {
+	if len(rootIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(rootIDs))
+	for i, id := range rootIDs {
+		args[i] = id
+	}
+	visible, visibleArgs := visibleAuthors(viewerID, "idUser")
+	query := `WITH RECURSIVE thread(idComment) AS (
+			SELECT idComment FROM comments WHERE parentCommentID IN (` + placeholders(len(rootIDs)) + `)
+			UNION ALL
+			SELECT c.idComment FROM comments c JOIN thread t ON c.parentCommentID = t.idComment
+		)
+		SELECT ` + commentColumns + ` FROM comments` +
+		where("idComment IN (SELECT idComment FROM thread)", visible) + `
+		ORDER BY created_at, idComment`
+	return s.queryComments(ctx, query, append(args, visibleArgs...)...)
+}
+
+func (s *sqlStore) Search(ctx context.Context, q string, types []SearchType, page PageRequest) ([]SearchResult, error) {
+	match := s.dialect.searchMatch(q)
+	arms := make([]string, len(types))
+	var args []any
+	for i, t := range types {
+		arm := s.dialect.searchArm(t)
+		for range strings.Count(arm, "?") {
+			args = append(args, match)
+		}
+		visible, visibleArgs := visibleAuthors(0, searchAuthors[t])
+		arms[i] = arm + " AND " + visible
+		args = append(args, visibleArgs...)
+	}
+	query := strings.Join(arms, " UNION ALL ") + ` ORDER BY score DESC, kind, id LIMIT ? OFFSET ?`
+	rows, err := s.query(ctx, query, append(args, page.Limit, page.offset())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippet := s.dialect.searchSnippet(q)
+	var results []SearchResult
+	for rows.Next() {
+		r := SearchResult{position: page.offset() + len(results) + 1}
+		if err := rows.Scan(&r.Type, &r.ID, &r.PostID, &r.Snippet, &r.Score); err != nil {
+			return nil, err
+		}
+		r.Snippet = snippet(r.Snippet)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func (s *sqlStore) Follow(ctx context.Context, followerID, followeeID int, at time.Time) error {
+	query := `INSERT INTO follows (followerID, followeeID, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`
+	_, err := s.exec(ctx, query, followerID, followeeID, formatTime(at))
+	return s.missingReference(ctx, err, reference{"userID", "users", "idUser", followeeID})
+}
+
+func (s *sqlStore) Unfollow(ctx context.Context, followerID, followeeID int) error {
+	return expectAffected(s.exec(ctx, `DELETE FROM follows WHERE followerID = ? AND followeeID = ?`, followerID, followeeID))
+}
+
+// listFollows lists the users on the other side of userID's follows: the
+// followers if side is "followerID", the followees if it is "followeeID".
+func (s *sqlStore) listFollows(ctx context.Context, userID int, side, other string, page PageRequest) ([]FollowUser, error) {
+	cond, args, order := keyset(page, "f.created_at", "u.idUser")
+	args = append([]any{userID}, args...)
+	query := `SELECT u.idUser, u.username, u.displayName, u.email, u.role, f.created_at
+		FROM follows f JOIN users u ON u.idUser = f.` + side + where("f."+other+" = ?", cond) + order
+	rows, err := s.query(ctx, query, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []FollowUser
+	for rows.Next() {
+		var f FollowUser
+		f.User, err = scanUser(rows, timestamp{&f.FollowedAt})
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, f)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) ListFollowers(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error) {
+	return s.listFollows(ctx, userID, "followerID", "followeeID", page)
+}
+
+func (s *sqlStore) ListFollowing(ctx context.Context, userID int, page PageRequest) ([]FollowUser, error) {
+	return s.listFollows(ctx, userID, "followeeID", "followerID", page)
+}
+
+func (s *sqlStore) FollowCounts(ctx context.Context, userID int) (int, int, error) {
+	var followers, following int
+	err := s.queryRow(ctx, `SELECT
+			(SELECT COUNT(*) FROM follows WHERE followeeID = ?),
+			(SELECT COUNT(*) FROM follows WHERE followerID = ?)`, userID, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+func (s *sqlStore) ListFolloweePosts(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	visible, visibleArgs := visibleAuthors(userID, "userID")
+	cond, pageArgs, order := keyset(page, "created_at", "idPost")
+	args := append(append([]any{userID}, visibleArgs...), pageArgs...)
+	query := `SELECT ` + postColumns + ` FROM posts` +
+		where("userID IN (SELECT followeeID FROM follows WHERE followerID = ?)", "deleted_at IS NULL", visible, cond) + order
+	return s.queryPosts(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) ListTimeline(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	visible, visibleArgs := visibleAuthors(userID, "p.userID")
+	cond, pageArgs, order := keyset(page, "t.created_at", "t.idPost")
+	args := append(append([]any{userID}, visibleArgs...), pageArgs...)
+	query := `SELECT p.idPost, p.content_text, p.created_at, p.userID, p.deleted_at, p.hidden_at
+		FROM timeline_entries t JOIN posts p ON p.idPost = t.idPost` +
+		where("t.userID = ?", "p.deleted_at IS NULL", visible, cond) + order
+	return s.queryPosts(ctx, query, append(args, page.Limit)...)
+}
+
+// timelineEntries inserts the timeline rows implied by follows and posts.
+// Callers append a WHERE clause to narrow it down.
+const timelineEntries = `INSERT INTO timeline_entries (userID, idPost, created_at)
+	SELECT f.followerID, p.idPost, p.created_at FROM follows f JOIN posts p ON p.userID = f.followeeID`
+
+func (s *sqlStore) FanOutPost(ctx context.Context, postID int) error {
+	_, err := s.exec(ctx, timelineEntries+` WHERE p.idPost = ? ON CONFLICT DO NOTHING`, postID)
+	return err
+}
+
+func (s *sqlStore) AddToTimeline(ctx context.Context, followerID, followeeID int) error {
+	_, err := s.exec(ctx, timelineEntries+` WHERE f.followerID = ? AND f.followeeID = ? ON CONFLICT DO NOTHING`,
+		followerID, followeeID)
+	return err
+}
+
+func (s *sqlStore) RemoveFromTimeline(ctx context.Context, followerID, followeeID int) error {
+	_, err := s.exec(ctx, `DELETE FROM timeline_entries
+		WHERE userID = ? AND idPost IN (SELECT idPost FROM posts WHERE userID = ?)`, followerID, followeeID)
+	return err
+}
+
+func (s *sqlStore) RebuildTimelines(ctx context.Context) error {
+	return s.inTx(ctx, func(tx sqlTx) error {
+		if _, err := tx.exec(ctx, `DELETE FROM timeline_entries`); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, timelineEntries+` WHERE true`)
+		return err
+	})
+}
+
+// reactionColumn is the reactions column that refers to targets of type t.
+func reactionColumn(t ReactionTarget) string {
+	if t == ReactToPost {
+		return "idPost"
+	}
+	return "idComment"
+}
+
+func (s *sqlStore) AddReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string, at time.Time) error {
+	query := `INSERT INTO reactions (` + reactionColumn(target) + `, idUser, reaction, created_at)
+		VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	_, err := s.exec(ctx, query, targetID, userID, reaction, formatTime(at))
+	targetRef := reference{"postID", "posts", "idPost", targetID}
+	if target == ReactToComment {
+		targetRef = reference{"commentID", "comments", "idComment", targetID}
+	}
+	return s.missingReference(ctx, err, targetRef, reference{"userID", "users", "idUser", userID})
+}
+
+func (s *sqlStore) RemoveReaction(ctx context.Context, target ReactionTarget, targetID, userID int, reaction string) error {
+	query := `DELETE FROM reactions WHERE ` + reactionColumn(target) + ` = ? AND idUser = ? AND reaction = ?`
+	return expectAffected(s.exec(ctx, query, targetID, userID, reaction))
+}
+
+func (s *sqlStore) ListReactions(ctx context.Context, target ReactionTarget, targetID int, reaction string, page PageRequest) ([]Reaction, error) {
+	args := []any{targetID}
+	byType := ""
+	if reaction != "" {
+		byType = "r.reaction = ?"
+		args = append(args, reaction)
+	}
+	cond, keysetArgs, order := keyset(page, "r.created_at", "r.idReaction")
+	args = append(append(args, keysetArgs...), page.Limit)
+	query := `SELECT r.idReaction, u.idUser, u.username, u.displayName, r.reaction, r.created_at
+		FROM reactions r JOIN users u ON u.idUser = r.idUser` +
+		where("r."+reactionColumn(target)+" = ?", byType, cond) + order
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []Reaction
+	for rows.Next() {
+		var r Reaction
+		err := rows.Scan(&r.IDReaction, &r.UserID, &r.Username, &r.DisplayName, &r.Reaction, timestamp{&r.CreatedAt})
+		if err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	return reactions, rows.Err()
+}
+
+func (s *sqlStore) ReactionSummaries(ctx context.Context, target ReactionTarget, targetIDs []int, viewerID int) (map[int]ReactionSummary, error) {
+	summaries := map[int]ReactionSummary{}
+	if len(targetIDs) == 0 {
+		return summaries, nil
+	}
+	args := []any{viewerID}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	column := reactionColumn(target)
+	query := `SELECT ` + column + `, reaction, COUNT(*), MAX(CASE WHEN idUser = ? THEN 1 ELSE 0 END)
+		FROM reactions WHERE ` + column + ` IN (` + placeholders(len(targetIDs)) + `)
+		GROUP BY ` + column + `, reaction ORDER BY ` + column + `, reaction`
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count, reacted int
+		var reaction string
+		if err := rows.Scan(&id, &reaction, &count, &reacted); err != nil {
+			return nil, err
+		}
+		summary, ok := summaries[id]
+		if !ok {
+			summary.Counts = map[string]int{}
+		}
+		summary.Counts[reaction] = count
+		if reacted == 1 {
+			summary.Reacted = append(summary.Reacted, reaction)
+		}
+		summaries[id] = summary
+	}
+	return summaries, rows.Err()
+}
+
+// nullID stores the ID 0 as NULL.
+func nullID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (s *sqlStore) Notify(ctx context.Context, e NotificationEvent) error {
+	at := formatTime(e.At)
+	return s.inTx(ctx, func(tx sqlTx) error {
+		var id int
+		err := tx.queryRow(ctx, `SELECT idNotification FROM notifications
+			WHERE userID = ? AND kind = ? AND COALESCE(idPost, 0) = ? AND COALESCE(idComment, 0) = ? AND read_at IS NULL`,
+			e.UserID, e.Kind, e.PostID, e.CommentID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			query := `INSERT INTO notifications (userID, kind, idPost, idComment, actorID, actorCount, updated_at)
+				VALUES (?, ?, ?, ?, ?, 1, ?) RETURNING idNotification`
+			err := tx.queryRow(ctx, query, e.UserID, e.Kind, nullID(e.PostID), nullID(e.CommentID), e.ActorID, at).Scan(&id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.exec(ctx, `INSERT INTO notification_actors (idNotification, actorID) VALUES (?, ?)`, id, e.ActorID)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		// Repeated events of the same actor neither count nor reorder
+		result, err := tx.exec(ctx, `INSERT INTO notification_actors (idNotification, actorID) VALUES (?, ?)
+			ON CONFLICT DO NOTHING`, id, e.ActorID)
+		if err != nil {
+			return err
+		}
+		if added, err := result.RowsAffected(); err != nil || added == 0 {
+			return err
+		}
+		_, err = tx.exec(ctx, `UPDATE notifications SET actorID = ?, actorCount = actorCount + 1, updated_at = ?
+			WHERE idNotification = ?`, e.ActorID, at, id)
+		return err
+	})
+}
+
+func (s *sqlStore) ListNotifications(ctx context.Context, userID int, unreadOnly bool, page PageRequest) ([]Notification, error) {
+	unread := ""
+	if unreadOnly {
+		unread = "n.read_at IS NULL"
+	}
+	cond, args, order := keyset(page, "n.updated_at", "n.idNotification")
+	args = append([]any{userID}, args...)
+	query := `SELECT n.idNotification, n.kind, COALESCE(n.idPost, 0), COALESCE(n.idComment, 0),
+			n.actorID, u.displayName, n.actorCount, n.updated_at, n.read_at
+		FROM notifications n JOIN users u ON u.idUser = n.actorID` +
+		where("n.userID = ?", unread, cond) + order
+	rows, err := s.query(ctx, query, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var readAt string
+		err := rows.Scan(&n.IDNotification, &n.Kind, &n.PostID, &n.CommentID,
+			&n.ActorID, &n.ActorName, &n.ActorCount, timestamp{&n.UpdatedAt}, timestamp{&readAt})
+		if err != nil {
+			return nil, err
+		}
+		if readAt != "" {
+			n.ReadAt = &readAt
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *sqlStore) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM notifications WHERE userID = ? AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) MarkNotificationRead(ctx context.Context, userID, id int, at time.Time) error {
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE idNotification = ? AND userID = ?`
+	return expectAffected(s.exec(ctx, query, formatTime(at), id, userID))
+}
+
+func (s *sqlStore) MarkAllNotificationsRead(ctx context.Context, userID int, at time.Time) (int, error) {
+	result, err := s.exec(ctx, `UPDATE notifications SET read_at = ? WHERE userID = ? AND read_at IS NULL`,
+		formatTime(at), userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// contentMatch is the condition that selects the mentions or hashtags of ref.
+func contentMatch(ref ContentRef) (string, any) {
+	if ref.CommentID != 0 {
+		return "idComment = ?", ref.CommentID
+	}
+	return "idPost = ? AND idComment IS NULL", ref.PostID
+}
+
+func (s *sqlStore) SetContentLinks(ctx context.Context, ref ContentRef, links ContentLinks) ([]int, error) {
+	match, matchArg := contentMatch(ref)
+	// The rows take the creation time of their post or comment, so editing
+	// old content does not make its hashtags trend
+	source := `SELECT idPost, CAST(NULL AS INTEGER), created_at FROM posts WHERE idPost = ?`
+	sourceArg := ref.PostID
+	if ref.CommentID != 0 {
+		source = `SELECT idPost, idComment, created_at FROM comments WHERE idComment = ?`
+		sourceArg = ref.CommentID
+	}
+
+	var added []int
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		before, err := tx.queryIDs(ctx, `SELECT userID FROM mentions WHERE `+match, matchArg)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.exec(ctx, `DELETE FROM mentions WHERE `+match, matchArg); err != nil {
+			return err
+		}
+		if _, err := tx.exec(ctx, `DELETE FROM hashtags WHERE `+match, matchArg); err != nil {
+			return err
+		}
+
+		for _, tag := range links.Tags {
+			query := `INSERT INTO hashtags (tag, idPost, idComment, created_at)
+				SELECT CAST(? AS TEXT), src.* FROM (` + source + `) src`
+			if _, err := tx.exec(ctx, query, tag, sourceArg); err != nil {
+				return err
+			}
+		}
+		if len(links.Usernames) == 0 {
+			return nil
+		}
+
+		args := []any{sourceArg}
+		for _, username := range links.Usernames {
+			args = append(args, username)
+		}
+		query := `INSERT INTO mentions (userID, idPost, idComment, created_at)
+			SELECT u.idUser, src.* FROM users u, (` + source + `) src
+			WHERE u.username IN (` + placeholders(len(links.Usernames)) + `)`
+		if _, err := tx.exec(ctx, query, args...); err != nil {
+			return err
+		}
+		after, err := tx.queryIDs(ctx, `SELECT userID FROM mentions WHERE `+match+` ORDER BY userID`, matchArg)
+		for _, id := range after {
+			if !slices.Contains(before, id) {
+				added = append(added, id)
+			}
+		}
+		return err
+	})
+	return added, err
+}
+
+func (s *sqlStore) ListPostsByTag(ctx context.Context, tag string, page PageRequest) ([]Post, error) {
+	visible, visibleArgs := visibleAuthors(0, "userID")
+	cond, pageArgs, order := keyset(page, "created_at", "idPost")
+	args := append(append([]any{tag}, visibleArgs...), pageArgs...)
+	query := `SELECT ` + postColumns + ` FROM posts` +
+		where("idPost IN (SELECT idPost FROM hashtags WHERE tag = ? AND idComment IS NULL)", "deleted_at IS NULL", visible, cond) + order
+	return s.queryPosts(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) TrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error) {
+	query := `SELECT h.tag, COUNT(*) AS uses FROM hashtags h JOIN posts p ON p.idPost = h.idPost
+		WHERE h.created_at >= ? AND p.deleted_at IS NULL
+		GROUP BY h.tag ORDER BY uses DESC, h.tag LIMIT ?`
+	rows, err := s.query(ctx, query, formatTime(since), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Uses); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+const attachmentColumns = `idAttachment, idPost, blob_key, thumbnail_key, content_type, width, height, size, alt_text, created_at`
+
+func scanAttachment(row scanner) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.IDAttachment, &a.PostID, &a.BlobKey, &a.ThumbnailKey, &a.ContentType, &a.Width, &a.Height,
+		&a.Size, &a.AltText, timestamp{&a.CreatedAt})
+	return a, err
+}
+
+func (s *sqlStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	query := `INSERT INTO attachments (idPost, blob_key, thumbnail_key, content_type, width, height, size, alt_text, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING idAttachment`
+	err := s.queryRow(ctx, query, a.PostID, a.BlobKey, a.ThumbnailKey, a.ContentType, a.Width, a.Height,
+		a.Size, a.AltText, a.CreatedAt).Scan(&a.IDAttachment)
+	return s.missingReference(ctx, err, reference{"postID", "posts", "idPost", a.PostID})
+}
+
+func (s *sqlStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE idAttachment = ?`
+	a, err := scanAttachment(s.queryRow(ctx, query, id))
+	return a, notFound(err)
+}
+
+func (s *sqlStore) ListAttachments(ctx context.Context, postIDs []int) (map[int][]Attachment, error) {
+	attachments := map[int][]Attachment{}
+	if len(postIDs) == 0 {
+		return attachments, nil
+	}
+	args := make([]any, len(postIDs))
+	for i, id := range postIDs {
+		args[i] = id
+	}
+	query := `SELECT ` + attachmentColumns + ` FROM attachments
+		WHERE idPost IN (` + placeholders(len(postIDs)) + `) ORDER BY idAttachment`
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[a.PostID] = append(attachments[a.PostID], a)
+	}
+	return attachments, rows.Err()
+}
+
+func (s *sqlStore) UpdateAttachmentAltText(ctx context.Context, id int, altText string) error {
+	return expectAffected(s.exec(ctx, `UPDATE attachments SET alt_text = ? WHERE idAttachment = ?`, altText, id))
+}
+
+func (s *sqlStore) DeleteAttachment(ctx context.Context, id int) error {
+	return expectAffected(s.exec(ctx, `DELETE FROM attachments WHERE idAttachment = ?`, id))
+}
+
+func (s *sqlStore) CreateConversation(ctx context.Context, c *Conversation, memberIDs []int) error {
+	var key any
+	if c.Direct {
+		key = directKey(memberIDs[0], memberIDs[1])
+	}
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		if c.Direct {
+			err := tx.queryRow(ctx, `SELECT idConversation FROM conversations WHERE direct_key = ?`, key).Scan(&c.IDConversation)
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		query := `INSERT INTO conversations (title, direct_key, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING idConversation`
+		if err := tx.queryRow(ctx, query, c.Title, key, c.CreatedAt, c.UpdatedAt).Scan(&c.IDConversation); err != nil {
+			return err
+		}
+		for _, userID := range memberIDs {
+			_, err := tx.exec(ctx, `INSERT INTO conversation_members (idConversation, userID, joined_at) VALUES (?, ?, ?)`,
+				c.IDConversation, userID, c.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	refs := make([]reference, len(memberIDs))
+	for i, userID := range memberIDs {
+		refs[i] = reference{"userID", "users", "idUser", userID}
+	}
+	return s.missingReference(ctx, err, refs...)
+}
+
+const conversationColumns = `c.idConversation, c.title, c.direct_key IS NOT NULL, c.created_at, c.updated_at`
+
+func scanConversation(row scanner) (Conversation, error) {
+	var c Conversation
+	err := row.Scan(&c.IDConversation, &c.Title, &c.Direct, timestamp{&c.CreatedAt}, timestamp{&c.UpdatedAt})
+	return c, err
+}
+
+func (s *sqlStore) GetConversation(ctx context.Context, id, viewerID int) (Conversation, error) {
+	query := `SELECT ` + conversationColumns + ` FROM conversations c
+		JOIN conversation_members m ON m.idConversation = c.idConversation
+		WHERE c.idConversation = ? AND m.userID = ?`
+	c, err := scanConversation(s.queryRow(ctx, query, id, viewerID))
+	if err != nil {
+		return c, notFound(err)
+	}
+	conversations := []Conversation{c}
+	err = s.conversationDetails(ctx, viewerID, conversations)
+	return conversations[0], err
+}
+
+func (s *sqlStore) ListConversations(ctx context.Context, userID int, page PageRequest) ([]Conversation, error) {
+	cond, args, order := keyset(page, "c.updated_at", "c.idConversation")
+	args = append([]any{userID}, args...)
+	query := `SELECT ` + conversationColumns + ` FROM conversations c
+		JOIN conversation_members m ON m.idConversation = c.idConversation` +
+		where("m.userID = ?", cond) + order
+	rows, err := s.query(ctx, query, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return conversations, s.conversationDetails(ctx, userID, conversations)
+}
+
+// conversationDetails fills in the members, last message and unread count
+// of conversations as seen by viewerID.
+func (s *sqlStore) conversationDetails(ctx context.Context, viewerID int, conversations []Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	index := map[int]int{}
+	ids := make([]any, len(conversations))
+	for i, c := range conversations {
+		index[c.IDConversation] = i
+		ids[i] = c.IDConversation
+	}
+	in := `(` + placeholders(len(ids)) + `)`
+
+	rows, err := s.query(ctx, `SELECT m.idConversation, u.idUser, u.username, u.displayName, m.joined_at,
+			m.last_read_message_id, m.read_at
+		FROM conversation_members m JOIN users u ON u.idUser = m.userID
+		WHERE m.idConversation IN `+in+` ORDER BY m.joined_at, u.idUser`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var m ConversationMember
+		var readAt string
+		err := rows.Scan(&id, &m.UserID, &m.Username, &m.DisplayName, timestamp{&m.JoinedAt},
+			&m.LastReadMessageID, timestamp{&readAt})
+		if err != nil {
+			return err
+		}
+		if readAt != "" {
+			m.ReadAt = &readAt
+		}
+		c := &conversations[index[id]]
+		c.Members = append(c.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	last, err := s.queryMessages(ctx, `SELECT `+messageColumns+` FROM messages WHERE idMessage IN
+		(SELECT MAX(idMessage) FROM messages WHERE idConversation IN `+in+` GROUP BY idConversation)`, ids...)
+	if err != nil {
+		return err
+	}
+	for _, m := range last {
+		conversations[index[m.ConversationID]].LastMessage = &m
+	}
+
+	rows, err = s.query(ctx, `SELECT msg.idConversation, COUNT(*) FROM messages msg
+		JOIN conversation_members m ON m.idConversation = msg.idConversation AND m.userID = ?
+		WHERE msg.idConversation IN `+in+` AND msg.idMessage > m.last_read_message_id AND msg.senderID <> ?
+		GROUP BY msg.idConversation`, append([]any{viewerID}, append(ids, viewerID)...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, unread int
+		if err := rows.Scan(&id, &unread); err != nil {
+			return err
+		}
+		conversations[index[id]].UnreadCount = unread
+	}
+	return rows.Err()
+}
+
+const messageColumns = `idMessage, idConversation, senderID, content_text, created_at`
+
+func (s *sqlStore) queryMessages(ctx context.Context, query string, args ...any) ([]Message, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.IDMessage, &m.ConversationID, &m.SenderID, &m.ContentText, timestamp{&m.CreatedAt}); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlStore) CreateMessage(ctx context.Context, m *Message) error {
+	return s.inTx(ctx, func(tx sqlTx) error {
+		var member int
+		err := tx.queryRow(ctx, `SELECT 1 FROM conversation_members WHERE idConversation = ? AND userID = ?`,
+			m.ConversationID, m.SenderID).Scan(&member)
+		if err != nil {
+			return notFound(err)
+		}
+		query := `INSERT INTO messages (idConversation, senderID, content_text, created_at) VALUES (?, ?, ?, ?) RETURNING idMessage`
+		if err := tx.queryRow(ctx, query, m.ConversationID, m.SenderID, m.ContentText, m.CreatedAt).Scan(&m.IDMessage); err != nil {
+			return err
+		}
+		if _, err := tx.exec(ctx, `UPDATE conversations SET updated_at = ? WHERE idConversation = ?`, m.CreatedAt, m.ConversationID); err != nil {
+			return err
+		}
+		_, err = tx.exec(ctx, `UPDATE conversation_members SET last_read_message_id = ?, read_at = ?
+			WHERE idConversation = ? AND userID = ?`, m.IDMessage, m.CreatedAt, m.ConversationID, m.SenderID)
+		return err
+	})
+}
+
+func (s *sqlStore) ListMessages(ctx context.Context, conversationID int, page PageRequest) ([]Message, error) {
+	cond, args, order := keyset(page, "created_at", "idMessage")
+	args = append([]any{conversationID}, args...)
+	query := `SELECT ` + messageColumns + ` FROM messages` + where("idConversation = ?", cond) + order
+	return s.queryMessages(ctx, query, append(args, page.Limit)...)
+}
+
+func (s *sqlStore) MarkConversationRead(ctx context.Context, conversationID, userID, messageID int, at time.Time) (ReadReceipt, error) {
+	receipt := ReadReceipt{ConversationID: conversationID, UserID: userID}
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		var err error
+		if messageID == 0 {
+			err = tx.queryRow(ctx, `SELECT COALESCE(MAX(idMessage), 0) FROM messages WHERE idConversation = ?`,
+				conversationID).Scan(&messageID)
+		} else {
+			err = tx.queryRow(ctx, `SELECT idMessage FROM messages WHERE idMessage = ? AND idConversation = ?`,
+				messageID, conversationID).Scan(&messageID)
+		}
+		if err != nil {
+			return notFound(err)
+		}
+		_, err = tx.exec(ctx, `UPDATE conversation_members SET last_read_message_id = ?, read_at = ?
+			WHERE idConversation = ? AND userID = ? AND last_read_message_id < ?`,
+			messageID, formatTime(at), conversationID, userID, messageID)
+		if err != nil {
+			return err
+		}
+		err = tx.queryRow(ctx, `SELECT last_read_message_id, read_at FROM conversation_members WHERE idConversation = ? AND userID = ?`,
+			conversationID, userID).Scan(&receipt.LastReadMessageID, timestamp{&receipt.ReadAt})
+		return notFound(err)
+	})
+	return receipt, err
+}
+
+func (s *sqlStore) Restrict(ctx context.Context, kind Restriction, userID, targetID int, at time.Time) error {
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		_, err := tx.exec(ctx, `INSERT INTO restrictions (userID, targetID, kind, created_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			userID, targetID, kind, formatTime(at))
+		if err != nil || kind != RestrictBlock {
+			return err
+		}
+		_, err = tx.exec(ctx, `DELETE FROM follows WHERE (followerID = ? AND followeeID = ?) OR (followerID = ? AND followeeID = ?)`,
+			userID, targetID, targetID, userID)
+		return err
+	})
+	return s.missingReference(ctx, err, reference{"userID", "users", "idUser", targetID})
+}
+
+func (s *sqlStore) Unrestrict(ctx context.Context, kind Restriction, userID, targetID int) error {
+	return expectAffected(s.exec(ctx, `DELETE FROM restrictions WHERE userID = ? AND targetID = ? AND kind = ?`, userID, targetID, kind))
+}
+
+func (s *sqlStore) ListRestricted(ctx context.Context, kind Restriction, userID int, page PageRequest) ([]RestrictedUser, error) {
+	cond, args, order := keyset(page, "r.created_at", "u.idUser")
+	args = append([]any{userID, kind}, args...)
+	query := `SELECT u.idUser, u.username, u.displayName, u.email, u.role, r.created_at
+		FROM restrictions r JOIN users u ON u.idUser = r.targetID` + where("r.userID = ?", "r.kind = ?", cond) + order
+	rows, err := s.query(ctx, query, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []RestrictedUser
+	for rows.Next() {
+		var r RestrictedUser
+		r.User, err = scanUser(rows, timestamp{&r.CreatedAt})
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, r)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) Restricts(ctx context.Context, userID, targetID int, kind Restriction) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM restrictions WHERE userID = ? AND targetID = ? AND (kind = ? OR ? = ''))`
+	var restricted bool
+	err := s.queryRow(ctx, query, userID, targetID, kind, kind).Scan(&restricted)
+	return restricted, err
+}
+
+const reportColumns = `idReport, reporterID, idPost, idComment, authorID, content_text, reason, details, status,
+	created_at, claimedBy, claimed_at, resolvedBy, resolved_at, action, note`
+
+func scanReport(row scanner) (Report, error) {
+	var r Report
+	var claimedAt, resolvedAt string
+	err := row.Scan(&r.IDReport, &r.ReporterID, &r.PostID, &r.CommentID, &r.AuthorID, &r.ContentText, &r.Reason, &r.Details,
+		&r.Status, timestamp{&r.CreatedAt}, &r.ClaimedBy, timestamp{&claimedAt}, &r.ResolvedBy, timestamp{&resolvedAt},
+		&r.Action, &r.Note)
+	if claimedAt != "" {
+		r.ClaimedAt = &claimedAt
+	}
+	if resolvedAt != "" {
+		r.ResolvedAt = &resolvedAt
+	}
+	return r, err
+}
+
+func (s *sqlStore) CreateReport(ctx context.Context, r *Report) error {
+	commentID := 0
+	if r.CommentID != nil {
+		commentID = *r.CommentID
+	}
+	err := s.inTx(ctx, func(tx sqlTx) error {
+		var pending bool
+		query := `SELECT EXISTS (SELECT 1 FROM reports
+			WHERE reporterID = ? AND idPost = ? AND COALESCE(idComment, 0) = ? AND status <> ?)`
+		if err := tx.queryRow(ctx, query, r.ReporterID, r.PostID, commentID, ReportResolved).Scan(&pending); err != nil {
+			return err
+		}
+		if pending {
+			return &ConflictError{Field: "report"}
+		}
+		query = `INSERT INTO reports (reporterID, idPost, idComment, authorID, content_text, reason, details, status, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING idReport`
+		return tx.queryRow(ctx, query, r.ReporterID, r.PostID, r.CommentID, r.AuthorID, r.ContentText, r.Reason, r.Details,
+			r.Status, r.CreatedAt).Scan(&r.IDReport)
+	})
+	return s.missingReference(ctx, err,
+		reference{"userID", "users", "idUser", r.ReporterID},
+		reference{"userID", "users", "idUser", r.AuthorID})
+}
+
+func (s *sqlStore) GetReport(ctx context.Context, id int) (Report, error) {
+	r, err := scanReport(s.queryRow(ctx, `SELECT `+reportColumns+` FROM reports WHERE idReport = ?`, id))
+	return r, notFound(err)
+}
+
+func (s *sqlStore) ListReports(ctx context.Context, status ReportStatus, page PageRequest) ([]Report, error) {
+	cond, args, order := keyset(page, "created_at", "idReport")
+	args = append([]any{status}, args...)
+	rows, err := s.query(ctx, `SELECT `+reportColumns+` FROM reports`+where("status = ?", cond)+order, append(args, page.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// reportAvailable matches the reports moderatorID can work on.
+const reportAvailable = `idReport = ? AND (status = 'open' OR status = 'claimed' AND claimedBy = ?)`
+
+func (s *sqlStore) ClaimReport(ctx context.Context, id, moderatorID int, at time.Time) error {
+	query := `UPDATE reports SET status = ?, claimedBy = ?, claimed_at = COALESCE(claimed_at, ?) WHERE ` + reportAvailable
+	return expectAffected(s.exec(ctx, query, ReportClaimed, moderatorID, formatTime(at), id, moderatorID))
+}
+
+func (s *sqlStore) ResolveReport(ctx context.Context, id, moderatorID int, action ModerationAction, note string, at time.Time) error {
+	now := formatTime(at)
+	query := `UPDATE reports SET status = ?, claimedBy = ?, claimed_at = COALESCE(claimed_at, ?),
+		resolvedBy = ?, resolved_at = ?, action = ?, note = ? WHERE ` + reportAvailable
+	return expectAffected(s.exec(ctx, query, ReportResolved, moderatorID, now, moderatorID, now, action, note, id, moderatorID))
+}
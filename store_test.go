@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file are the contract of Store: every case runs against
+// each implementation in storeFactories, on an empty store.
+
+type storeFactory struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+var storeFactories = []storeFactory{
+	{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+	{"sqlite", openTestSQLiteStore},
+}
+
+// openTestSQLiteStore creates a migrated SQLite database in a temporary
+// directory of the test.
+func openTestSQLiteStore(t *testing.T) Store {
+	s, err := openSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := migrateUp(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func forEachStore(t *testing.T, fn func(t *testing.T, s Store)) {
+	for _, f := range storeFactories {
+		t.Run(f.name, func(t *testing.T) { fn(t, f.open(t)) })
+	}
+}
+
+// storeFixture creates rows with creation times that increase by a minute
+// from start, so that their order is known.
+type storeFixture struct {
+	t     *testing.T
+	s     Store
+	ctx   context.Context
+	start time.Time
+	n     int
+}
+
+func newStoreFixture(t *testing.T, s Store) *storeFixture {
+	return &storeFixture{t: t, s: s, ctx: context.Background(), start: time.Now().Add(-48 * time.Hour).Truncate(time.Second)}
+}
+
+func (f *storeFixture) next() string {
+	f.n++
+	return formatTime(f.start.Add(time.Duration(f.n) * time.Minute))
+}
+
+func (f *storeFixture) user(name string) User {
+	f.t.Helper()
+	u := User{Username: name, DisplayName: name, Email: name + "@example.com", Role: RoleUser}
+	if err := f.s.CreateUser(f.ctx, &u, "hash"); err != nil {
+		f.t.Fatal(err)
+	}
+	return u
+}
+
+func (f *storeFixture) post(userID int, text string) Post {
+	f.t.Helper()
+	p := Post{ContentText: text, CreatedAt: f.next(), UserID: userID}
+	if err := f.s.CreatePost(f.ctx, &p); err != nil {
+		f.t.Fatal(err)
+	}
+	return p
+}
+
+func (f *storeFixture) comment(postID, userID int, parentID *int, text string) Comment {
+	f.t.Helper()
+	c := Comment{IDPost: postID, IDUser: userID, ParentID: parentID, ContentText: text, CreatedAt: f.next()}
+	if err := f.s.CreateComment(f.ctx, &c); err != nil {
+		f.t.Fatal(err)
+	}
+	return c
+}
+
+// postIDs and commentIDs take the results of a List method. A failed query
+// gives [-1], so that it shows up as a mismatch.
+func postIDs(posts []Post, err error) []int {
+	ids := []int{}
+	for _, p := range posts {
+		ids = append(ids, p.IDPost)
+	}
+	if err != nil {
+		return []int{-1}
+	}
+	return ids
+}
+
+func commentIDs(comments []Comment, err error) []int {
+	ids := []int{}
+	for _, c := range comments {
+		ids = append(ids, c.IDComment)
+	}
+	if err != nil {
+		return []int{-1}
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, what string, got []int, want ...int) {
+	t.Helper()
+	if want == nil {
+		want = []int{}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func expectErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s: err = %v, want %v", what, err, want)
+	}
+}
+
+func expectConflict(t *testing.T, what string, err error, field string) {
+	t.Helper()
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Field != field {
+		t.Errorf("%s: err = %v, want a conflict on %s", what, err, field)
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice := f.user("alice")
+		bob := f.user("bob")
+
+		dup := User{Username: "carol", DisplayName: "carol", Email: alice.Email, Role: RoleUser}
+		expectConflict(t, "CreateUser with a taken email", s.CreateUser(f.ctx, &dup, "hash"), "email")
+		dup = User{Username: "alice", DisplayName: "carol", Email: "carol@example.com", Role: RoleUser}
+		expectConflict(t, "CreateUser with a taken username", s.CreateUser(f.ctx, &dup, "hash"), "username")
+
+		for _, login := range []string{"alice", "alice@example.com"} {
+			u, hash, err := s.GetUserByLogin(f.ctx, login)
+			if err != nil || u.IDUser != alice.IDUser || hash != "hash" {
+				t.Errorf("GetUserByLogin(%q) = %d, %q, %v, want %d", login, u.IDUser, hash, err, alice.IDUser)
+			}
+		}
+		_, _, err := s.GetUserByLogin(f.ctx, "nobody")
+		expectErr(t, "GetUserByLogin of an unknown login", err, ErrNotFound)
+
+		// Only the email column is searched, even if a username looks like
+		// the email of someone else
+		lookalike := User{Username: alice.Email, DisplayName: "mallory", Email: "mallory@example.com", Role: RoleUser}
+		if err := s.CreateUser(f.ctx, &lookalike, "hash"); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := s.GetUserByEmail(f.ctx, alice.Email); err != nil || u.IDUser != alice.IDUser {
+			t.Errorf("GetUserByEmail(%q) = %d, %v, want %d", alice.Email, u.IDUser, err, alice.IDUser)
+		}
+		_, err = s.GetUserByEmail(f.ctx, "nobody@example.com")
+		expectErr(t, "GetUserByEmail of an unknown email", err, ErrNotFound)
+
+		bob.DisplayName = "Bob"
+		if err := s.UpdateUser(f.ctx, bob); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.GetUser(f.ctx, bob.IDUser); err != nil || got.DisplayName != "Bob" || got.Email != bob.Email {
+			t.Errorf("GetUser after UpdateUser = %+v, %v", got, err)
+		}
+		bob.Email = alice.Email
+		expectConflict(t, "UpdateUser to a taken email", s.UpdateUser(f.ctx, bob), "email")
+		expectErr(t, "UpdateUser of an unknown user", s.UpdateUser(f.ctx, User{IDUser: 999, Username: "x"}), ErrNotFound)
+
+		if err := s.SetUserRole(f.ctx, bob.IDUser, RoleModerator); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.GetUser(f.ctx, bob.IDUser); got.Role != RoleModerator {
+			t.Errorf("role after SetUserRole = %q, want %q", got.Role, RoleModerator)
+		}
+		expectErr(t, "SetUserRole of an unknown user", s.SetUserRole(f.ctx, 999, RoleAdmin), ErrNotFound)
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice := f.user("alice")
+		expires := f.start.Add(time.Hour)
+		if err := s.CreateSession(f.ctx, "token", alice.IDUser, f.start, expires); err != nil {
+			t.Fatal(err)
+		}
+
+		u, got, err := s.GetSessionUser(f.ctx, "token")
+		if err != nil || u.IDUser != alice.IDUser || !got.Equal(expires) {
+			t.Errorf("GetSessionUser = %d, %v, %v, want %d, %v", u.IDUser, got, err, alice.IDUser, expires)
+		}
+		if err := s.DeleteSession(f.ctx, "token"); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = s.GetSessionUser(f.ctx, "token")
+		expectErr(t, "GetSessionUser after DeleteSession", err, ErrNotFound)
+	})
+}
+
+func TestStorePostsAndComments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice := f.user("alice")
+		bob := f.user("bob")
+		p1 := f.post(alice.IDUser, "first")
+		p2 := f.post(bob.IDUser, "second")
+		c1 := f.comment(p1.IDPost, bob.IDUser, nil, "reply")
+		f.comment(p2.IDPost, alice.IDUser, nil, "other")
+		c3 := f.comment(p1.IDPost, alice.IDUser, nil, "answer")
+
+		expectIDs(t, "ListPosts", postIDs(s.ListPosts(f.ctx, 0, firstPage)), p2.IDPost, p1.IDPost)
+		expectIDs(t, "ListPostsByUser", postIDs(s.ListPostsByUser(f.ctx, bob.IDUser, 0, firstPage)), p2.IDPost)
+		expectIDs(t, "ListCommentsByPost", commentIDs(s.ListCommentsByPost(f.ctx, p1.IDPost, 0, firstPage)), c3.IDComment, c1.IDComment)
+
+		if err := s.UpdatePostContent(f.ctx, p1.IDPost, "edited", alice.IDUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.GetPost(f.ctx, p1.IDPost); err != nil || got.ContentText != "edited" || got.UserID != alice.IDUser {
+			t.Errorf("GetPost after UpdatePostContent = %+v, %v", got, err)
+		}
+		expectErr(t, "UpdatePostContent of an unknown post", s.UpdatePostContent(f.ctx, 999, "x", alice.IDUser, time.Now()), ErrNotFound)
+
+		if err := s.DeletePost(f.ctx, p2.IDPost, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		_, err := s.GetPost(f.ctx, p2.IDPost)
+		expectErr(t, "GetPost after DeletePost", err, ErrNotFound)
+	})
+}
+
+var firstPage = PageRequest{Limit: 100, Sort: SortNewest}
+
+func TestStorePagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		u := f.user("alice")
+		var ids []int
+		for i := range 5 {
+			ids = append(ids, f.post(u.IDUser, "post "+strconv.Itoa(i)).IDPost)
+		}
+		// A post created at the same time as an earlier one sorts after it
+		tie := Post{ContentText: "tie", CreatedAt: formatTime(f.start.Add(3 * time.Minute)), UserID: u.IDUser}
+		if err := s.CreatePost(f.ctx, &tie); err != nil {
+			t.Fatal(err)
+		}
+		oldest := []int{ids[0], ids[1], ids[2], tie.IDPost, ids[3], ids[4]}
+		newest := slices.Clone(oldest)
+		slices.Reverse(newest)
+
+		for _, sort := range []SortOrder{SortNewest, SortOldest} {
+			var got []int
+			page := PageRequest{Limit: 2, Sort: sort}
+			for pages := 0; pages < 10; pages++ {
+				posts, err := s.ListPosts(f.ctx, 0, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, postIDs(posts, nil)...)
+				if len(posts) < page.Limit {
+					break
+				}
+				last := posts[len(posts)-1]
+				page.After = &Cursor{Sort: sort, CreatedAt: last.CreatedAt, ID: last.IDPost}
+			}
+			want := newest
+			if sort == SortOldest {
+				want = oldest
+			}
+			expectIDs(t, "ListPosts "+string(sort), got, want...)
+		}
+
+		post := f.post(u.IDUser, "commented")
+		var comments []int
+		for i := range 3 {
+			comments = append(comments, f.comment(post.IDPost, u.IDUser, nil, "comment "+strconv.Itoa(i)).IDComment)
+		}
+		page := PageRequest{Limit: 2, Sort: SortOldest}
+		first, err := s.ListCommentsByPost(f.ctx, post.IDPost, 0, page)
+		expectIDs(t, "first comment page", commentIDs(first, err), comments[:2]...)
+		page.After = &Cursor{Sort: SortOldest, CreatedAt: first[1].CreatedAt, ID: first[1].IDComment}
+		expectIDs(t, "second comment page", commentIDs(s.ListCommentsByPost(f.ctx, post.IDPost, 0, page)), comments[2])
+	})
+}
+
+func TestStoreSoftDeleteAndPurge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		u := f.user("alice")
+		kept := f.post(u.IDUser, "kept")
+		deleted := f.post(u.IDUser, "deleted")
+		recent := f.post(u.IDUser, "deleted recently")
+		hidden := f.post(u.IDUser, "hidden")
+		longAgo := f.start.Add(-24 * time.Hour)
+
+		if err := s.DeletePost(f.ctx, deleted.IDPost, longAgo); err != nil {
+			t.Fatal(err)
+		}
+		expectErr(t, "DeletePost twice", s.DeletePost(f.ctx, deleted.IDPost, longAgo), ErrNotFound)
+		_, err := s.GetPost(f.ctx, deleted.IDPost)
+		expectErr(t, "GetPost of a deleted post", err, ErrNotFound)
+		if p, err := s.GetDeletedPost(f.ctx, deleted.IDPost); err != nil || p.DeletedAt == nil {
+			t.Errorf("GetDeletedPost = %+v, %v", p, err)
+		}
+		_, err = s.GetDeletedPost(f.ctx, kept.IDPost)
+		expectErr(t, "GetDeletedPost of a live post", err, ErrNotFound)
+		expectIDs(t, "ListPosts", postIDs(s.ListPosts(f.ctx, 0, firstPage)), hidden.IDPost, recent.IDPost, kept.IDPost)
+
+		if err := s.RestorePost(f.ctx, deleted.IDPost); err != nil {
+			t.Fatal(err)
+		}
+		expectErr(t, "RestorePost of a live post", s.RestorePost(f.ctx, deleted.IDPost), ErrNotFound)
+		expectIDs(t, "ListPosts after restore", postIDs(s.ListPosts(f.ctx, 0, firstPage)), hidden.IDPost, recent.IDPost, deleted.IDPost, kept.IDPost)
+
+		f.comment(deleted.IDPost, u.IDUser, nil, "goes with the post")
+		if err := s.DeletePost(f.ctx, deleted.IDPost, longAgo); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeletePost(f.ctx, recent.IDPost, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.HidePost(f.ctx, hidden.IDPost, longAgo); err != nil {
+			t.Fatal(err)
+		}
+		expectErr(t, "HidePost twice", s.HidePost(f.ctx, hidden.IDPost, longAgo), ErrNotFound)
+		n, _, err := s.PurgeDeletedPosts(f.ctx, f.start)
+		if err != nil || n != 1 {
+			t.Errorf("PurgeDeletedPosts = %d, %v, want 1", n, err)
+		}
+		_, err = s.GetDeletedPost(f.ctx, deleted.IDPost)
+		expectErr(t, "GetDeletedPost of a purged post", err, ErrNotFound)
+		if _, err := s.GetDeletedPost(f.ctx, recent.IDPost); err != nil {
+			t.Errorf("GetDeletedPost of a post deleted after the cutoff: %v", err)
+		}
+		if p, err := s.GetDeletedPost(f.ctx, hidden.IDPost); err != nil || p.HiddenAt == nil {
+			t.Errorf("hidden post after purge = %+v, %v", p, err)
+		}
+	})
+}
+
+func TestStoreCommentTombstones(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		u := f.user("alice")
+		post := f.post(u.IDUser, "post")
+		root := f.comment(post.IDPost, u.IDUser, nil, "root")
+		reply := f.comment(post.IDPost, u.IDUser, &root.IDComment, "reply")
+		leaf := f.comment(post.IDPost, u.IDUser, nil, "leaf")
+		page := PageRequest{Limit: 100, Sort: SortOldest}
+
+		if err := s.DeleteComment(f.ctx, root.IDComment, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteComment(f.ctx, leaf.IDComment, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		expectErr(t, "DeleteComment twice", s.DeleteComment(f.ctx, leaf.IDComment, time.Now()), ErrNotFound)
+		comments, err := s.ListCommentsByPost(f.ctx, post.IDPost, 0, page)
+		expectIDs(t, "comments after delete", commentIDs(comments, err), root.IDComment, reply.IDComment)
+		if len(comments) == 2 && (!comments[0].Deleted || comments[0].ContentText != deletedCommentText) {
+			t.Errorf("deleted root = %+v, want a tombstone", comments[0])
+		}
+
+		// Deleting the last reply removes the tombstone it kept alive
+		if err := s.DeleteComment(f.ctx, reply.IDComment, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		expectIDs(t, "comments after deleting the reply", commentIDs(s.ListCommentsByPost(f.ctx, post.IDPost, 0, page)))
+
+		hidden := f.comment(post.IDPost, u.IDUser, nil, "hidden")
+		if err := s.HideComment(f.ctx, hidden.IDComment, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		comments, err = s.ListCommentsByPost(f.ctx, post.IDPost, 0, page)
+		expectIDs(t, "comments after hiding", commentIDs(comments, err), hidden.IDComment)
+		if len(comments) == 1 && (!comments[0].Hidden || comments[0].ContentText != hiddenCommentText) {
+			t.Errorf("hidden comment = %+v, want a tombstone", comments[0])
+		}
+	})
+}
+
+func TestStoreRestrictions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice, bob, carol := f.user("alice"), f.user("bob"), f.user("carol")
+		bobPost := f.post(bob.IDUser, "by bob")
+		carolPost := f.post(carol.IDUser, "by carol")
+		bobComment := f.comment(carolPost.IDPost, bob.IDUser, nil, "bob replies")
+		reply := f.comment(carolPost.IDPost, carol.IDUser, &bobComment.IDComment, "carol answers")
+
+		if err := s.Restrict(f.ctx, RestrictMute, alice.IDUser, bob.IDUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Restrict(f.ctx, RestrictMute, alice.IDUser, bob.IDUser, time.Now()); err != nil {
+			t.Errorf("Restrict twice: %v", err)
+		}
+		var missing *ReferenceError
+		if err := s.Restrict(f.ctx, RestrictMute, alice.IDUser, 9999, time.Now()); !errors.As(err, &missing) {
+			t.Errorf("Restrict of an unknown user: err = %v, want *ReferenceError", err)
+		}
+
+		for _, c := range []struct {
+			userID, targetID int
+			kind             Restriction
+			want             bool
+		}{
+			{alice.IDUser, bob.IDUser, "", true},
+			{alice.IDUser, bob.IDUser, RestrictMute, true},
+			{alice.IDUser, bob.IDUser, RestrictBlock, false},
+			{bob.IDUser, alice.IDUser, "", false},
+		} {
+			if got, err := s.Restricts(f.ctx, c.userID, c.targetID, c.kind); err != nil || got != c.want {
+				t.Errorf("Restricts(%d, %d, %q) = %v, %v, want %v", c.userID, c.targetID, c.kind, got, err, c.want)
+			}
+		}
+
+		expectIDs(t, "ListPosts of alice", postIDs(s.ListPosts(f.ctx, alice.IDUser, firstPage)), carolPost.IDPost)
+		expectIDs(t, "ListPosts of anonymous", postIDs(s.ListPosts(f.ctx, 0, firstPage)), carolPost.IDPost, bobPost.IDPost)
+		expectIDs(t, "ListPostsByUser of alice", postIDs(s.ListPostsByUser(f.ctx, bob.IDUser, alice.IDUser, firstPage)))
+		expectIDs(t, "ListCommentsByPost of alice",
+			commentIDs(s.ListCommentsByPost(f.ctx, carolPost.IDPost, alice.IDUser, firstPage)), reply.IDComment)
+		expectIDs(t, "ListRootComments of alice", commentIDs(s.ListRootComments(f.ctx, carolPost.IDPost, alice.IDUser, firstPage)))
+		expectIDs(t, "ListCommentsByPost of bob",
+			commentIDs(s.ListCommentsByPost(f.ctx, carolPost.IDPost, bob.IDUser, firstPage)), reply.IDComment, bobComment.IDComment)
+
+		// Following a muted user does not bring their posts back
+		if err := s.Follow(f.ctx, alice.IDUser, bob.IDUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddToTimeline(f.ctx, alice.IDUser, bob.IDUser); err != nil {
+			t.Fatal(err)
+		}
+		expectIDs(t, "ListFolloweePosts of alice", postIDs(s.ListFolloweePosts(f.ctx, alice.IDUser, firstPage)))
+		expectIDs(t, "ListTimeline of alice", postIDs(s.ListTimeline(f.ctx, alice.IDUser, firstPage)))
+
+		// Blocking ends the follows in both directions
+		for _, pair := range [][2]int{{alice.IDUser, carol.IDUser}, {carol.IDUser, alice.IDUser}} {
+			if err := s.Follow(f.ctx, pair[0], pair[1], time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Restrict(f.ctx, RestrictBlock, alice.IDUser, carol.IDUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if followers, err := s.ListFollowers(f.ctx, alice.IDUser, firstPage); err != nil || len(followers) != 0 {
+			t.Errorf("followers of alice after blocking = %v, %v", followers, err)
+		}
+		if following, err := s.ListFollowing(f.ctx, alice.IDUser, firstPage); err != nil || len(following) != 1 || following[0].IDUser != bob.IDUser {
+			t.Errorf("following of alice after blocking = %v, %v, want bob only", following, err)
+		}
+		if blocked, err := s.ListRestricted(f.ctx, RestrictBlock, alice.IDUser, firstPage); err != nil || len(blocked) != 1 || blocked[0].IDUser != carol.IDUser {
+			t.Errorf("ListRestricted = %v, %v", blocked, err)
+		}
+
+		if err := s.Unrestrict(f.ctx, RestrictMute, alice.IDUser, bob.IDUser); err != nil {
+			t.Fatal(err)
+		}
+		expectErr(t, "Unrestrict twice", s.Unrestrict(f.ctx, RestrictMute, alice.IDUser, bob.IDUser), ErrNotFound)
+		expectIDs(t, "ListPostsByUser after unmuting", postIDs(s.ListPostsByUser(f.ctx, bob.IDUser, alice.IDUser, firstPage)), bobPost.IDPost)
+		expectIDs(t, "ListFolloweePosts after unmuting", postIDs(s.ListFolloweePosts(f.ctx, alice.IDUser, firstPage)), bobPost.IDPost)
+		expectIDs(t, "ListTimeline after unmuting", postIDs(s.ListTimeline(f.ctx, alice.IDUser, firstPage)), bobPost.IDPost)
+	})
+}
+
+func TestStoreSuspensionFiltering(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice, bob, mod := f.user("alice"), f.user("bob"), f.user("mod")
+		alicePost := f.post(alice.IDUser, "by alice #news")
+		bobPost := f.post(bob.IDUser, "by bob #news")
+		bobComment := f.comment(alicePost.IDPost, bob.IDUser, nil, "bob was here")
+		for _, p := range []Post{alicePost, bobPost} {
+			if _, err := s.SetContentLinks(f.ctx, ContentRef{PostID: p.IDPost}, ContentLinks{Tags: []string{"news"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Follow(f.ctx, alice.IDUser, bob.IDUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddToTimeline(f.ctx, alice.IDUser, bob.IDUser); err != nil {
+			t.Fatal(err)
+		}
+
+		visible := func(what string, bobVisible bool) {
+			t.Helper()
+			want := []int{alicePost.IDPost}
+			if bobVisible {
+				want = []int{bobPost.IDPost, alicePost.IDPost}
+			}
+			expectIDs(t, what+": ListPosts", postIDs(s.ListPosts(f.ctx, 0, firstPage)), want...)
+			expectIDs(t, what+": ListPostsByTag", postIDs(s.ListPostsByTag(f.ctx, "news", firstPage)), want...)
+			own := []int{}
+			if bobVisible {
+				own = []int{bobPost.IDPost}
+			}
+			expectIDs(t, what+": ListPostsByUser", postIDs(s.ListPostsByUser(f.ctx, bob.IDUser, 0, firstPage)), own...)
+			expectIDs(t, what+": ListFolloweePosts", postIDs(s.ListFolloweePosts(f.ctx, alice.IDUser, firstPage)), own...)
+			expectIDs(t, what+": ListTimeline", postIDs(s.ListTimeline(f.ctx, alice.IDUser, firstPage)), own...)
+			comments := []int{}
+			if bobVisible {
+				comments = []int{bobComment.IDComment}
+			}
+			expectIDs(t, what+": ListCommentsByPost", commentIDs(s.ListCommentsByPost(f.ctx, alicePost.IDPost, 0, firstPage)), comments...)
+			for _, c := range []struct {
+				t  SearchType
+				id int
+			}{{SearchPost, bobPost.IDPost}, {SearchComment, bobComment.IDComment}, {SearchUser, bob.IDUser}} {
+				results, err := s.Search(f.ctx, "bob", []SearchType{c.t}, PageRequest{Limit: 10})
+				found := err == nil && len(results) == 1 && results[0].ID == c.id
+				if found != bobVisible || err != nil {
+					t.Errorf("%s: Search of %s = %+v, %v, want found %v", what, c.t, results, err, bobVisible)
+				}
+			}
+		}
+
+		_, err := s.GetSuspension(f.ctx, bob.IDUser)
+		expectErr(t, "GetSuspension before suspending", err, ErrNotFound)
+		expectErr(t, "LiftSuspension before suspending", s.LiftSuspension(f.ctx, bob.IDUser), ErrNotFound)
+		visible("not suspended", true)
+
+		if err := s.SuspendUser(f.ctx, bob.IDUser, newSuspension(mod.IDUser, "spam", 3, false, time.Now())); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetSuspension(f.ctx, bob.IDUser)
+		if err != nil || got.Reason != "spam" || got.ModeratorID != mod.IDUser || got.HideContent || got.Until == nil {
+			t.Errorf("GetSuspension = %+v, %v", got, err)
+		}
+		visible("suspended without hiding", true)
+
+		if err := s.SuspendUser(f.ctx, bob.IDUser, newSuspension(mod.IDUser, "abuse", 0, true, time.Now())); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.GetSuspension(f.ctx, bob.IDUser); err != nil || !got.HideContent || got.Until != nil {
+			t.Errorf("GetSuspension = %+v, %v", got, err)
+		}
+		visible("suspended and hidden", false)
+
+		expired := newSuspension(mod.IDUser, "old", 1, true, time.Now().AddDate(0, 0, -2))
+		if err := s.SuspendUser(f.ctx, bob.IDUser, expired); err != nil {
+			t.Fatal(err)
+		}
+		visible("suspension expired", true)
+
+		if err := s.SuspendUser(f.ctx, bob.IDUser, newSuspension(mod.IDUser, "again", 0, true, time.Now())); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LiftSuspension(f.ctx, bob.IDUser); err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.GetSuspension(f.ctx, bob.IDUser)
+		expectErr(t, "GetSuspension after lifting", err, ErrNotFound)
+		visible("suspension lifted", true)
+		expectErr(t, "SuspendUser of an unknown user", s.SuspendUser(f.ctx, 9999, Suspension{SuspendedAt: formatTime(time.Now())}), ErrNotFound)
+	})
+}
+
+func TestStoreSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		f := newStoreFixture(t, s)
+		alice, bob := f.user("alice"), f.user("bobby")
+		fox := f.post(alice.IDUser, "the quick brown fox jumps")
+		gone := f.post(alice.IDUser, "a fox that was deleted")
+		dog := f.comment(fox.IDPost, bob.IDUser, nil, "the lazy dog sleeps")
+		if err := s.DeletePost(f.ctx, gone.IDPost, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		all := []SearchType{SearchPost, SearchComment, SearchUser}
+		page := PageRequest{Limit: 10}
+
+		for _, c := range []struct {
+			q    string
+			want []SearchResult
+		}{
+			{"fox", []SearchResult{{Type: SearchPost, ID: fox.IDPost, PostID: fox.IDPost}}},
+			{"dog", []SearchResult{{Type: SearchComment, ID: dog.IDComment, PostID: fox.IDPost}}},
+			{"bobby", []SearchResult{{Type: SearchUser, ID: bob.IDUser}}},
+			{"cat", nil},
+		} {
+			results, err := s.Search(f.ctx, c.q, all, page)
+			if err != nil {
+				t.Errorf("Search(%q): %v", c.q, err)
+				continue
+			}
+			if len(results) != len(c.want) {
+				t.Errorf("Search(%q) = %+v, want %+v", c.q, results, c.want)
+				continue
+			}
+			for i, r := range results {
+				w := c.want[i]
+				if r.Type != w.Type || r.ID != w.ID || r.PostID != w.PostID || !strings.Contains(r.Snippet, c.q) {
+					t.Errorf("Search(%q)[%d] = %+v, want %+v", c.q, i, r, w)
+				}
+			}
+		}
+		if results, err := s.Search(f.ctx, "fox", []SearchType{SearchComment}, page); err != nil || len(results) != 0 {
+			t.Errorf("Search of comments only = %+v, %v", results, err)
+		}
+
+		// Snippets are HTML: the text is escaped, only the highlights are markup
+		f.post(bob.IDUser, `<img src=x onerror="alert(1)"> owl`)
+		results, err := s.Search(f.ctx, "owl", all, page)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Search(owl) = %+v, %v", results, err)
+		}
+		if snippet := results[0].Snippet; strings.Contains(snippet, "<img") || !strings.Contains(snippet, "&lt;img") ||
+			!strings.Contains(snippet, highlightStart+"owl"+highlightEnd) {
+			t.Errorf("snippet = %q, want escaped text with owl highlighted", snippet)
+		}
+
+		// Case is ignored beyond ASCII as well
+		cafe := f.post(alice.IDUser, "Über das Café der École")
+		for _, q := range []string{"über", "CAFÉ", "ÉCOLE"} {
+			if results, err := s.Search(f.ctx, q, all, page); err != nil || len(results) != 1 || results[0].ID != cafe.IDPost {
+				t.Errorf("Search(%q) = %+v, %v, want post %d", q, results, err, cafe.IDPost)
+			}
+		}
+	})
+}
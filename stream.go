@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// publishPost sends the current state of a post to the feed and to the
+// subscribers of the post. A failed lookup is only logged, as the request
+// that changed the post has already succeeded.
+func (s *Server) publishPost(ctx context.Context, eventType string, postID int) {
+	post, err := s.store.GetPost(ctx, postID)
+	if err != nil {
+		log.Printf("stream: %s %d: %v", eventType, postID, err)
+		return
+	}
+	posts := []Post{post}
+	if err := s.withAttachments(ctx, posts); err != nil {
+		log.Printf("stream: %s %d: %v", eventType, postID, err)
+	}
+	post = posts[0]
+	s.hub.PublishContent(eventType, post.UserID, post, topicPosts, topicPost(postID))
+}
+
+// Stream handles GET /stream, a Server-Sent Events stream of the topics
+// given as ?topic=: "posts" for the feed and "post:<id>" for the comments of
+// a post. Authenticated callers also receive their notifications. Since
+// EventSource cannot send headers, the session token may be passed as
+// ?token= instead.
+//
+// Posts and comments by users the caller blocked or muted, or whose
+// suspension hides their content, are left out, as in the lists. Every event carries an id; browsers send the last one back as
+// Last-Event-ID when they reconnect, and the events missed in between are
+// replayed. A comment line is written every STREAM_HEARTBEAT to keep proxies
+// from closing idle connections.
+func (s *Server) Stream(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user := currentUser(c)
+	if token := c.QueryParam("token"); token != "" && user == nil {
+		var err error
+		user, err = s.userForSession(ctx, token)
+		if errors.Is(err, errInvalidSession) {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Session is invalid or has expired"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to load session"})
+		}
+	}
+
+	var topics []string
+	for _, topic := range c.QueryParams()["topic"] {
+		if topic == topicPosts {
+			topics = append(topics, topic)
+			continue
+		}
+		value, found := strings.CutPrefix(topic, "post:")
+		postID, ok := parseID(value)
+		if !found || !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "topic must be posts or post:<id>, got " + topic})
+		}
+		if _, err := s.store.GetPost(ctx, postID); errors.Is(err, ErrNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Post not found"})
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query post"})
+		}
+		topics = append(topics, topicPost(postID))
+	}
+	if user != nil {
+		topics = append(topics, topicUser(user.IDUser))
+	}
+	if len(topics) == 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "At least one topic is required"})
+	}
+
+	var lastID uint64
+	if value := c.Request().Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid Last-Event-ID"})
+		}
+		lastID = id
+	}
+
+	sub := s.hub.Subscribe(topics, lastID)
+	defer s.hub.Unsubscribe(sub)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	heartbeat := time.NewTicker(s.cfg.StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case e, ok := <-sub.Events:
+			if !ok {
+				// Dropped by the hub for falling behind
+				return nil
+			}
+			if e.AuthorID != 0 {
+				// Looked up per event, so restrictions and suspensions apply
+				// to open streams
+				hidden, err := s.hidesAuthor(ctx, user, e.AuthorID)
+				if err != nil {
+					// The client reconnects and catches up from the history
+					log.Printf("stream: visibility of user %d: %v", e.AuthorID, err)
+					return nil
+				}
+				if hidden {
+					continue
+				}
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data); err != nil {
+				return nil
+			}
+		}
+		w.Flush()
+	}
+}
+
+// hidesAuthor reports whether the stream of viewer, who may be nil, leaves
+// out the posts and comments of authorID.
+func (s *Server) hidesAuthor(ctx context.Context, viewer *User, authorID int) (bool, error) {
+	suspension, err := s.activeSuspension(ctx, authorID)
+	if err != nil {
+		return false, err
+	}
+	if suspension != nil && suspension.HideContent {
+		return true, nil
+	}
+	if viewer == nil {
+		return false, nil
+	}
+	return s.store.Restricts(ctx, viewer.IDUser, authorID, "")
+}
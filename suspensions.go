@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxSuspensionDays caps suspensions with an end. Longer ones are given
+// without an end instead.
+const maxSuspensionDays = 3650
+
+// maxSuspensionReasonLength caps the reason of a suspension, in characters.
+const maxSuspensionReasonLength = 1000
+
+// Suspension keeps a user from using their account.
+type Suspension struct {
+	Reason      string `json:"reason"`
+	SuspendedAt string `json:"suspended_at"`
+	// Until is when the suspension ends, nil if it lasts until lifted.
+	Until *string `json:"suspended_until"`
+	// ModeratorID is who suspended the user.
+	ModeratorID int `json:"suspendedBy"`
+	// HideContent leaves the posts and comments of the user out of lists
+	// while the suspension lasts.
+	HideContent bool `json:"hideContent"`
+}
+
+// newSuspension starts a suspension by moderatorID at now that lasts days,
+// or until it is lifted when days is 0.
+func newSuspension(moderatorID int, reason string, days int, hideContent bool, now time.Time) Suspension {
+	suspension := Suspension{
+		Reason:      reason,
+		SuspendedAt: formatTime(now),
+		ModeratorID: moderatorID,
+		HideContent: hideContent,
+	}
+	if days > 0 {
+		until := formatTime(now.AddDate(0, 0, days))
+		suspension.Until = &until
+	}
+	return suspension
+}
+
+// activeAt reports whether the suspension has not ended by t.
+func (s Suspension) activeAt(t time.Time) bool {
+	if s.Until == nil {
+		return true
+	}
+	until, err := time.Parse(time.RFC3339, *s.Until)
+	return err != nil || until.After(t)
+}
+
+// suspendUser records the suspension and signs the user out everywhere.
+func (s *Server) suspendUser(ctx context.Context, userID int, suspension Suspension) error {
+	if err := s.store.SuspendUser(ctx, userID, suspension); err != nil {
+		return err
+	}
+	return s.store.DeleteUserSessions(ctx, userID)
+}
+
+// activeSuspension returns the suspension of the user, or nil if they are
+// not suspended or it has ended.
+func (s *Server) activeSuspension(ctx context.Context, userID int) (*Suspension, error) {
+	suspension, err := s.store.GetSuspension(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !suspension.activeAt(time.Now()) {
+		return nil, nil
+	}
+	return &suspension, nil
+}
+
+// refuseSuspended writes a 403 response with the reason and end of the
+// suspension if the user is suspended. It returns true if the handler can go
+// on.
+func (s *Server) refuseSuspended(c echo.Context, userID int) (bool, error) {
+	suspension, err := s.activeSuspension(c.Request().Context(), userID)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query suspension"})
+	}
+	if suspension != nil {
+		return false, c.JSON(http.StatusForbidden, echo.Map{
+			"error":           "Your account is suspended",
+			"reason":          suspension.Reason,
+			"suspended_until": suspension.Until,
+		})
+	}
+	return true, nil
+}
+
+// rejectSuspended guards the routes that create or change content. A
+// suspension ends the sessions of the user and Login refuses them, so this
+// catches the sessions that slipped in between.
+func (s *Server) rejectSuspended(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := s.refuseSuspended(c, currentUser(c).IDUser); !ok {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// loadSuspendable looks up the user a moderator acts on and writes the 404
+// or 403 response if there is none or the caller may not suspend them.
+func (s *Server) loadSuspendable(c echo.Context, userID int) (User, bool, error) {
+	user, err := s.store.GetUser(c.Request().Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		return user, false, c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return user, false, c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query user"})
+	}
+	if !canSuspendUser(currentUser(c), user) {
+		return user, false, c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to suspend this user"})
+	}
+	return user, true, nil
+}
+
+// GetSuspension handles GET /suspension?userID=: the suspension in force for
+// the user, if any.
+func (s *Server) GetSuspension(c echo.Context) error {
+	userID, ok := parseID(c.QueryParam("userID"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid user ID"})
+	}
+
+	suspension, err := s.activeSuspension(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query suspension"})
+	}
+	if suspension == nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User is not suspended"})
+	}
+	return c.JSON(http.StatusOK, suspension)
+}
+
+// SuspendUser handles PUT /suspendUser {userID, reason, days, hideContent}.
+// It replaces any suspension already in force; without days it lasts until
+// lifted.
+func (s *Server) SuspendUser(c echo.Context) error {
+	type SuspendRequest struct {
+		UserID      string `json:"userID"`
+		Reason      string `json:"reason"`
+		Days        int    `json:"days"`
+		HideContent bool   `json:"hideContent"`
+	}
+
+	var req SuspendRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	userID, ok := parseID(req.UserID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "User ID is required"})
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Reason is required"})
+	}
+	if len([]rune(reason)) > maxSuspensionReasonLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Reason must not be longer than " + strconv.Itoa(maxSuspensionReasonLength) + " characters"})
+	}
+	if req.Days < 0 || req.Days > maxSuspensionDays {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "days must be between 1 and " + strconv.Itoa(maxSuspensionDays)})
+	}
+	if _, ok, err := s.loadSuspendable(c, userID); !ok {
+		return err
+	}
+
+	suspension := newSuspension(currentUser(c).IDUser, reason, req.Days, req.HideContent, time.Now())
+	if err := s.suspendUser(c.Request().Context(), userID, suspension); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to suspend user: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, suspension)
+}
+
+// LiftSuspension handles PUT /liftSuspension {userID}, which ends a
+// suspension early.
+func (s *Server) LiftSuspension(c echo.Context) error {
+	type LiftRequest struct {
+		UserID string `json:"userID"`
+	}
+
+	var req LiftRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request format"})
+	}
+	userID, ok := parseID(req.UserID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "User ID is required"})
+	}
+	if _, ok, err := s.loadSuspendable(c, userID); !ok {
+		return err
+	}
+
+	err := s.store.LiftSuspension(c.Request().Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User is not suspended"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to lift suspension: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Suspension lifted successfully"})
+}
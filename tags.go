@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultTrendingWindow is the period GET /tags/trending counts without
+	// ?window=, maxTrendingWindow the longest one it accepts.
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 30 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTagLength          = 64
+)
+
+var (
+	// mentionPattern matches @username where the @ does not follow a word
+	// character, so e-mail addresses are not mentions.
+	mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.]{3,32})`)
+	// hashtagPattern matches #tag where the # does not follow a word
+	// character or &, which leaves URL fragments and HTML entities alone.
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\w&#])#([\p{L}\p{N}_]+)`)
+)
+
+// ContentRef points to a post, or to a comment if CommentID is not 0.
+type ContentRef struct {
+	PostID    int
+	CommentID int
+}
+
+// ContentLinks are the mentions and hashtags found in a text.
+type ContentLinks struct {
+	Usernames []string
+	// Tags are lower case and without the #.
+	Tags []string
+}
+
+// TagCount is one entry of GET /tags/trending.
+type TagCount struct {
+	Tag  string `json:"tag"`
+	Uses int    `json:"uses"`
+}
+
+// parseContentLinks extracts the distinct mentions and hashtags of text.
+func parseContentLinks(text string) ContentLinks {
+	var links ContentLinks
+	seen := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// A sentence may end right after a mention
+		username := strings.TrimRight(m[1], ".")
+		if len(username) >= 3 && !seen["@"+username] {
+			seen["@"+username] = true
+			links.Usernames = append(links.Usernames, username)
+		}
+	}
+	for _, m := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(m[1])
+		if len([]rune(tag)) <= maxTagLength && !seen["#"+tag] {
+			seen["#"+tag] = true
+			links.Tags = append(links.Tags, tag)
+		}
+	}
+	return links
+}
+
+// linkContent saves the mentions and hashtags of a post or comment that was
+// just created or edited, and notifies users mentioned for the first time.
+// The content has already been stored, so failures are only logged.
+func (s *Server) linkContent(ctx context.Context, actorID int, ref ContentRef, text string) {
+	mentioned, err := s.store.SetContentLinks(ctx, ref, parseContentLinks(text))
+	if err != nil {
+		log.Printf("tags: link post %d comment %d: %v", ref.PostID, ref.CommentID, err)
+		return
+	}
+	if len(mentioned) > 0 && ref.PostID == 0 {
+		// Edited comments are referenced by their ID only
+		comment, err := s.store.GetComment(ctx, ref.CommentID)
+		if err != nil {
+			log.Printf("tags: notify mentions in comment %d: %v", ref.CommentID, err)
+			return
+		}
+		ref.PostID = comment.IDPost
+	}
+	s.notifyMentions(ctx, actorID, ref.PostID, ref.CommentID, mentioned)
+}
+
+// GetTagPosts handles GET /tags/:tag: the posts whose text has the hashtag,
+// newest first.
+func (s *Server) GetTagPosts(c echo.Context) error {
+	tag := strings.ToLower(strings.TrimPrefix(c.Param("tag"), "#"))
+	if tag == "" || len([]rune(tag)) > maxTagLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid tag"})
+	}
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	posts, err := s.store.ListPostsByTag(c.Request().Context(), tag, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query posts"})
+	}
+	postPage := newPage(posts, page, postCursor)
+	if err := s.withAttachments(c.Request().Context(), postPage.Items); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
+	}
+	return c.JSON(http.StatusOK, postPage)
+}
+
+// GetTrendingTags handles GET /tags/trending?window=&limit=: the hashtags
+// used most in posts and comments created within the window, such as 24h.
+func (s *Server) GetTrendingTags(c echo.Context) error {
+	window := defaultTrendingWindow
+	if value := c.QueryParam("window"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > maxTrendingWindow {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "window must be a duration of at most " + maxTrendingWindow.String()})
+		}
+		window = d
+	}
+	limit := defaultTrendingLimit
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > s.cfg.MaxPageSize {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "limit must be between 1 and " + strconv.Itoa(s.cfg.MaxPageSize)})
+		}
+		limit = n
+	}
+
+	tags, err := s.store.TrendingTags(c.Request().Context(), time.Now().Add(-window), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query tags"})
+	}
+	if tags == nil {
+		tags = []TagCount{}
+	}
+	return c.JSON(http.StatusOK, tags)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Timeline fan-out strategies, see Config.TimelineFanout.
+const (
+	// FanoutOnRead assembles timelines at request time from the posts of the
+	// followed accounts. Writes stay cheap, reads get slower as the number of
+	// followed accounts grows.
+	FanoutOnRead = "read"
+	// FanoutOnWrite copies every new post into the timelines of the author's
+	// followers, so reading a timeline is a single indexed range scan.
+	FanoutOnWrite = "write"
+)
+
+// timelineStrategy keeps home timelines up to date and reads them.
+type timelineStrategy interface {
+	list(ctx context.Context, userID int, page PageRequest) ([]Post, error)
+	posted(ctx context.Context, postID int)
+	followed(ctx context.Context, followerID, followeeID int)
+	unfollowed(ctx context.Context, followerID, followeeID int)
+}
+
+func newTimelineStrategy(store Store, fanout string) timelineStrategy {
+	if fanout == FanoutOnWrite {
+		return fanoutOnWrite{store}
+	}
+	return fanoutOnRead{store}
+}
+
+type fanoutOnRead struct{ store Store }
+
+func (t fanoutOnRead) list(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	return t.store.ListFolloweePosts(ctx, userID, page)
+}
+
+func (fanoutOnRead) posted(context.Context, int)          {}
+func (fanoutOnRead) followed(context.Context, int, int)   {}
+func (fanoutOnRead) unfollowed(context.Context, int, int) {}
+
+// fanoutOnWrite maintains the timeline entries alongside the request that
+// changes them. The request has already succeeded by then, so failures are
+// only logged; "timelines rebuild" repairs timelines that fell behind.
+type fanoutOnWrite struct{ store Store }
+
+func (t fanoutOnWrite) list(ctx context.Context, userID int, page PageRequest) ([]Post, error) {
+	return t.store.ListTimeline(ctx, userID, page)
+}
+
+func (t fanoutOnWrite) posted(ctx context.Context, postID int) {
+	if err := t.store.FanOutPost(ctx, postID); err != nil {
+		log.Printf("timeline: fan out post %d: %v", postID, err)
+	}
+}
+
+func (t fanoutOnWrite) followed(ctx context.Context, followerID, followeeID int) {
+	if err := t.store.AddToTimeline(ctx, followerID, followeeID); err != nil {
+		log.Printf("timeline: add posts of user %d for user %d: %v", followeeID, followerID, err)
+	}
+}
+
+func (t fanoutOnWrite) unfollowed(ctx context.Context, followerID, followeeID int) {
+	if err := t.store.RemoveFromTimeline(ctx, followerID, followeeID); err != nil {
+		log.Printf("timeline: remove posts of user %d for user %d: %v", followeeID, followerID, err)
+	}
+}
+
+// GetTimeline handles GET /timeline: the posts of the accounts the caller
+// follows, newest first.
+func (s *Server) GetTimeline(c echo.Context) error {
+	page, ok, err := s.parsePageRequest(c, SortNewest)
+	if !ok {
+		return err
+	}
+
+	posts, err := s.timeline.list(c.Request().Context(), currentUser(c).IDUser, page.probe())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query timeline"})
+	}
+	postPage := newPage(posts, page, postCursor)
+	if err := s.withAttachments(c.Request().Context(), postPage.Items); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to query attachments"})
+	}
+	return c.JSON(http.StatusOK, postPage)
+}
+
+// runTimelinesCommand implements the "timelines" subcommand:
+//
+//	timelines rebuild   materialize every timeline from the follow graph
+//
+// Run it when switching TIMELINE_FANOUT to write, and whenever timelines may
+// have missed updates.
+func runTimelinesCommand(cfg Config, args []string) {
+	if len(args) != 1 || args[0] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "usage: timelines rebuild")
+		os.Exit(2)
+	}
+	if cfg.Database == "memory" {
+		log.Fatal("timelines: the in-memory store has no timelines to rebuild")
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.RebuildTimelines(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("timelines rebuilt")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RestorePost undoes DeletePost for posts that have not been purged yet.
+func (s *Server) RestorePost(c echo.Context) error {
+	type RestoreRequest struct {
+		PostID string `json:"postID"`
+	}
+
+	var req RestoreRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	postID, ok := parseID(req.PostID)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Post ID is required",
+		})
+	}
+
+	ctx := c.Request().Context()
+	post, err := s.store.GetDeletedPost(ctx, postID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Deleted post not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to query post",
+		})
+	}
+
+	if !canRestorePost(currentUser(c), post.UserID) {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": "You are not allowed to restore this post",
+		})
+	}
+	if post.HiddenAt != nil && !canRestoreHiddenPost(currentUser(c)) {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": "This post was hidden by a moderator",
+		})
+	}
+
+	err = s.store.RestorePost(ctx, postID)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Deleted post not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": "Failed to restore post: " + err.Error(),
+		})
+	}
+	s.publishPost(ctx, EventPostRestored, postID)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Post restored successfully",
+	})
+}
+
+// runPurgeJob permanently deletes posts that have been soft-deleted for
+// longer than cfg.PostRetention, and the files of their attachments, once at startup and then every
+// cfg.PurgeInterval, until ctx is done.
+func (s *Server) runPurgeJob(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, blobKeys, err := s.store.PurgeDeletedPosts(ctx, time.Now().Add(-s.cfg.PostRetention))
+		if err != nil {
+			log.Printf("failed to purge deleted posts: %v", err)
+		} else if purged > 0 {
+			s.deleteBlobs(ctx, blobKeys...)
+			log.Printf("purged %d deleted posts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}